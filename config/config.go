@@ -16,22 +16,110 @@ type APPConfig struct {
 	Env     string
 }
 
+// KeySource configures one source of token signing keys
+type KeySource struct {
+	Name                   string   `json:"name" yaml:"name"`
+	Type                   string   `json:"type" yaml:"type"` // "graphql", "jwks" or "pem"
+	URL                    string   `json:"url" yaml:"url"`   // GraphQL endpoint, JWKS URL or JWKS file path
+	Files                  []string `json:"files" yaml:"files"`
+	Issuer                 string   `json:"issuer" yaml:"issuer"`
+	Audiences              []string `json:"audiences" yaml:"audiences"`
+	PollingDurationMinutes uint     `json:"polling_duration_minutes" yaml:"polling_duration_minutes"`
+	ServiceAuth            bool     `json:"service_auth" yaml:"service_auth"` // graphql sources only get the key service token and client certificate when set
+}
+
+// TokenSource configures one place a token is read from, sources are tried in order
+type TokenSource struct {
+	Type   string `json:"type" yaml:"type"`     // "header", "cookie", "query" or "websocket-protocol"
+	Name   string `json:"name" yaml:"name"`     // header, cookie or query parameter name
+	Scheme string `json:"scheme" yaml:"scheme"` // header value prefix, e.g. "Bearer", empty takes the whole value
+	Prefix string `json:"prefix" yaml:"prefix"` // WebSocket subprotocol prefix, "access_token." by default
+}
+
+// AccessRule restricts who may call matching GraphQL root fields, the first matching rule wins.
+// An empty matcher list matches everything, as does "*".
+type AccessRule struct {
+	Types      []string `json:"types" yaml:"types"` // "query", "mutation" or "subscription"
+	Operations []string `json:"operations" yaml:"operations"`
+	Fields     []string `json:"fields" yaml:"fields"`     // root field names
+	Purposes   []string `json:"purposes" yaml:"purposes"` // the token purpose has to be one of these
+	Scopes     []string `json:"scopes" yaml:"scopes"`     // the token needs all of these scopes
+	Roles      []string `json:"roles" yaml:"roles"`       // the token needs one of these roles
+}
+
 type Config struct {
-	Version                    string   `env:"APP__VERSION" default:"local"`
-	Port                       int      `env:"CONFIG__PORT" default:"8080"`
-	GraphQLEndpoint            string   `env:"INTERNAL_GRAPHQL_URL" default:"http://key-management:5001/graphql"`
-	ProxyAddress               string   `env:"CONFIG__PROXY_URL" default:"http://apollo-router:4000" json:"proxy_address"`
-	OverrideOrigin             *string  `env:"CONFIG__OVERRIDE_ORIGIN" json:"override_origin"`
-	KeysPollingDurationMinutes uint     `env:"CONFIG__KEYS_POLLING_DURATION_MINUTES" default:"15"`
-	CORSAllowedOrigins         []string `env:"CONFIG__CORS_ALLOWED_ORIGINS" json:"cors_allowed_origins"`
-	CORSAllowCredentials       bool     `env:"CONFIG__CORS_ALLOW_CREDENTIALS" default:"true" json:"cors_allow_credentials"`
-	CORSMaxAge                 int      `env:"CONFIG__CORS_MAX_AGE" default:"86400" json:"cors_max_age"`
-	AuthMode                   string   `env:"CONFIG__AUTH_MODE" default:"both" json:"auth_mode"` // "cookie", "header", or "both"
-	CacheEnabled               bool     `env:"CONFIG__CACHE_ENABLED" default:"true" json:"cache_enabled"`
-	CacheTTLMinutes            int      `env:"CONFIG__CACHE_TTL_MINUTES" default:"5" json:"cache_ttl_minutes"`
-	RedisURL                   string   `env:"CONFIG__REDIS_URL" default:"redis://localhost:6379" json:"redis_url"`
-	RedisPassword              string   `env:"CONFIG__REDIS_PASSWORD" default:"" json:"redis_password"`
-	RedisDB                    int      `env:"CONFIG__REDIS_DB" default:"0" json:"redis_db"`
+	Version                    string            `env:"APP__VERSION" default:"local"`
+	Port                       int               `env:"CONFIG__PORT" default:"8080"`
+	ShutdownDelaySeconds       int               `env:"CONFIG__SHUTDOWN_DELAY_SECONDS" default:"5" json:"shutdown_delay_seconds"`          // time to keep serving while /readyz reports draining, so load balancers stop routing here
+	ShutdownDrainSeconds       int               `env:"CONFIG__SHUTDOWN_DRAIN_SECONDS" default:"15" json:"shutdown_drain_seconds"`         // how long in-flight requests may take to complete once the listener is closed, delay + drain + 5s of tracing flush fit the default 30s grace period
+	ReadyKeysMaxAgeMinutes     int               `env:"CONFIG__READY_KEYS_MAX_AGE_MINUTES" default:"60" json:"ready_keys_max_age_minutes"` // /readyz fails when no key source fetched successfully for that long, 0 only requires keys
+	ReadyUpstreamURL           string            `env:"CONFIG__READY_UPSTREAM_URL" json:"ready_upstream_url"`                              // checked by /readyz when set, e.g. the router's health endpoint
+	GraphQLEndpoint            string            `env:"INTERNAL_GRAPHQL_URL" default:"http://key-management:5001/graphql"`
+	ProxyAddress               string            `env:"CONFIG__PROXY_URL" default:"http://apollo-router:4000" json:"proxy_address"`
+	OverrideOrigin             *string           `env:"CONFIG__OVERRIDE_ORIGIN" json:"override_origin"`
+	TrustedProxyHops           int               `env:"CONFIG__TRUSTED_PROXY_HOPS" default:"0" json:"trusted_proxy_hops"` // proxies in front of the gateway whose X-Forwarded-For entries are trusted, 0 uses the peer address
+	KeysPollingDurationMinutes uint              `env:"CONFIG__KEYS_POLLING_DURATION_MINUTES" default:"15"`
+	KeysRefreshIntervalSeconds uint              `env:"CONFIG__KEYS_REFRESH_INTERVAL_SECONDS" default:"10" json:"keys_refresh_interval_seconds"` // least time between refetches triggered by unknown kids
+	KeyRetirementMinutes       int               `env:"CONFIG__KEY_RETIREMENT_MINUTES" default:"60" json:"key_retirement_minutes"`               // how long keys removed from their source keep verifying tokens, should cover the token lifetime
+	KeySnapshotFile            string            `env:"CONFIG__KEY_SNAPSHOT_FILE" json:"key_snapshot_file"`                                      // last good key set, used to start while a key source is down, empty disables
+	KeySnapshotMaxAgeHours     int               `env:"CONFIG__KEY_SNAPSHOT_MAX_AGE_HOURS" default:"24" json:"key_snapshot_max_age_hours"`       // older snapshots aren't used to start
+	KeySources                 []KeySource       `env:"CONFIG__KEY_SOURCES" json:"key_sources"`                                                  // defaults to the key-management service at GraphQLEndpoint, the env variable takes the sources as a JSON list
+	KeyServiceTimeoutSeconds   int               `env:"CONFIG__KEY_SERVICE_TIMEOUT_SECONDS" default:"10" json:"key_service_timeout_seconds"`     // bounds a whole fetch from a graphql key source
+	KeyServiceToken            string            `env:"CONFIG__KEY_SERVICE_TOKEN" json:"key_service_token"`                                      // sent as a bearer token to the key-management service and graphql key sources with service_auth
+	KeyServiceCertFile         string            `env:"CONFIG__KEY_SERVICE_CERT_FILE" json:"key_service_cert_file"`                              // client certificate for mTLS to the key-management service and graphql key sources with service_auth
+	KeyServiceKeyFile          string            `env:"CONFIG__KEY_SERVICE_KEY_FILE" json:"key_service_key_file"`
+	KeyServiceCAFile           string            `env:"CONFIG__KEY_SERVICE_CA_FILE" json:"key_service_ca_file"` // CA bundle verifying graphql key sources, defaults to the system roots
+	CORSAllowedOrigins         []string          `env:"CONFIG__CORS_ALLOWED_ORIGINS" json:"cors_allowed_origins"`
+	CORSAllowCredentials       bool              `env:"CONFIG__CORS_ALLOW_CREDENTIALS" default:"true" json:"cors_allow_credentials"`
+	CORSMaxAge                 int               `env:"CONFIG__CORS_MAX_AGE" default:"86400" json:"cors_max_age"`
+	AuthMode                   string            `env:"CONFIG__AUTH_MODE" default:"both" json:"auth_mode"` // "cookie", "header", or "both"
+	TokenSources               []TokenSource     `env:"CONFIG__TOKEN_SOURCES" json:"token_sources"`        // replaces AuthMode when set
+	APIKeysEnabled             bool              `env:"CONFIG__API_KEYS_ENABLED" default:"false" json:"api_keys_enabled"`
+	APIKeyHeader               string            `env:"CONFIG__API_KEY_HEADER" default:"X-API-Key" json:"api_key_header"`
+	APIKeyStore                string            `env:"CONFIG__API_KEY_STORE" default:"file" json:"api_key_store"`          // "file" or "redis", keys are stored as SHA-256 hashes
+	APIKeyFile                 string            `env:"CONFIG__API_KEY_FILE" json:"api_key_file"`                           // JSON list of keys for the file store
+	APIKeyRateLimit            int               `env:"CONFIG__API_KEY_RATE_LIMIT" default:"600" json:"api_key_rate_limit"` // requests per key and minute, 0 disables
+	TLSEnabled                 bool              `env:"CONFIG__TLS_ENABLED" default:"false" json:"tls_enabled"`             // serve HTTPS on Port
+	TLSCertFile                string            `env:"CONFIG__TLS_CERT_FILE" json:"tls_cert_file"`
+	TLSKeyFile                 string            `env:"CONFIG__TLS_KEY_FILE" json:"tls_key_file"`
+	TLSClientCAFile            string            `env:"CONFIG__TLS_CLIENT_CA_FILE" json:"tls_client_ca_file"`                           // CA bundle verifying client certificates, enables mTLS
+	TLSClientAuth              string            `env:"CONFIG__TLS_CLIENT_AUTH" default:"verify-if-given" json:"tls_client_auth"`       // "verify-if-given" or "require"
+	MTLSPrincipal              string            `env:"CONFIG__MTLS_PRINCIPAL" default:"cn" json:"mtls_principal"`                      // "cn", "dns", "uri" or "email", where the principal is read from
+	TLSMinVersion              string            `env:"CONFIG__TLS_MIN_VERSION" default:"1.2" json:"tls_min_version"`                   // "1.2" or "1.3"
+	TLSCipherSuites            []string          `env:"CONFIG__TLS_CIPHER_SUITES" json:"tls_cipher_suites"`                             // TLS 1.2 suites by name, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, empty keeps Go's defaults
+	TLSReloadSeconds           int               `env:"CONFIG__TLS_RELOAD_SECONDS" default:"30" json:"tls_reload_seconds"`              // how often the certificate files are checked for changes, 0 disables reloading
+	AuthEnforcement            string            `env:"CONFIG__AUTH_ENFORCEMENT" default:"off" json:"auth_enforcement"`                 // "off", "reject-invalid", or "require-valid"
+	JWTAllowedAlgorithms       []string          `env:"CONFIG__JWT_ALLOWED_ALGORITHMS" default:"[RS256]" json:"jwt_allowed_algorithms"` // any of RS*, PS*, ES256/384/512 and EdDSA
+	JWTIssuers                 []string          `env:"CONFIG__JWT_ISSUERS" json:"jwt_issuers"`                                         // accepted when the key source doesn't pin an issuer, empty accepts any
+	JWTAudiences               []string          `env:"CONFIG__JWT_AUDIENCES" json:"jwt_audiences"`                                     // accepted when the key source doesn't pin audiences, empty accepts any
+	JWTLeewaySeconds           int               `env:"CONFIG__JWT_LEEWAY_SECONDS" default:"5" json:"jwt_leeway_seconds"`               // clock skew tolerated on exp, nbf and iat
+	JWTCacheSize               int               `env:"CONFIG__JWT_CACHE_SIZE" default:"10000" json:"jwt_cache_size"`                   // verified tokens kept in memory, 0 disables
+	ClaimHeaders               map[string]string `env:"CONFIG__CLAIM_HEADERS" json:"claim_headers"`                                     // claim path (dot separated) -> upstream header name
+	RefreshEndpoint            string            `env:"CONFIG__REFRESH_ENDPOINT" json:"refresh_endpoint"`                               // auth service endpoint trading a refresh_token cookie for new cookies, empty disables refreshing
+	RefreshTimeoutSeconds      int               `env:"CONFIG__REFRESH_TIMEOUT_SECONDS" default:"5" json:"refresh_timeout_seconds"`
+	AccessRules                []AccessRule      `env:"CONFIG__ACCESS_RULES" json:"access_rules"`
+	AccessDefault              string            `env:"CONFIG__ACCESS_DEFAULT" default:"allow" json:"access_default"`                 // "allow" or "deny", for operations no rule matches
+	AccessRolesClaim           string            `env:"CONFIG__ACCESS_ROLES_CLAIM" default:"roles" json:"access_roles_claim"`         // claim path holding the roles
+	AccessScopesClaim          string            `env:"CONFIG__ACCESS_SCOPES_CLAIM" default:"scope" json:"access_scopes_claim"`       // claim path holding the scopes, space separated or a list
+	InternalTokenEnabled       bool              `env:"CONFIG__INTERNAL_TOKEN_ENABLED" default:"false" json:"internal_token_enabled"` // forward a gateway signed token instead of x-raw-token
+	InternalTokenKeyFile       string            `env:"CONFIG__INTERNAL_TOKEN_KEY_FILE" json:"internal_token_key_file"`               // PEM RSA private key shared by every replica, required unless InternalTokenDevKey is set
+	InternalTokenDevKey        bool              `env:"CONFIG__INTERNAL_TOKEN_DEV_KEY" default:"false" json:"internal_token_dev_key"` // development only, generate a key at startup when no key file is configured
+	InternalTokenIssuer        string            `env:"CONFIG__INTERNAL_TOKEN_ISSUER" default:"gateway-proxy" json:"internal_token_issuer"`
+	InternalTokenAudience      string            `env:"CONFIG__INTERNAL_TOKEN_AUDIENCE" default:"subgraphs" json:"internal_token_audience"`
+	InternalTokenTTLSeconds    int               `env:"CONFIG__INTERNAL_TOKEN_TTL_SECONDS" default:"60" json:"internal_token_ttl_seconds"`
+	StrippedHeaders            []string          `env:"CONFIG__STRIPPED_HEADERS" json:"stripped_headers"`
+	ReservedHeaderPrefix       string            `env:"CONFIG__RESERVED_HEADER_PREFIX" default:"x-gateway-" json:"reserved_header_prefix"`
+	CacheEnabled               bool              `env:"CONFIG__CACHE_ENABLED" default:"true" json:"cache_enabled"`
+	CacheTTLMinutes            int               `env:"CONFIG__CACHE_TTL_MINUTES" default:"5" json:"cache_ttl_minutes"`
+	RedisURL                   string            `env:"CONFIG__REDIS_URL" default:"redis://localhost:6379" json:"redis_url"`
+	RedisPassword              string            `env:"CONFIG__REDIS_PASSWORD" default:"" json:"redis_password"`
+	RevocationEnabled          bool              `env:"CONFIG__REVOCATION_ENABLED" default:"false" json:"revocation_enabled"` // check verified tokens against the Redis deny-list
+	RevocationCacheSize        int               `env:"CONFIG__REVOCATION_CACHE_SIZE" default:"10000" json:"revocation_cache_size"`
+	RevocationCacheSeconds     int               `env:"CONFIG__REVOCATION_CACHE_SECONDS" default:"5" json:"revocation_cache_seconds"` // how long other replicas may take to see a revocation
+	RevocationTTLHours         int               `env:"CONFIG__REVOCATION_TTL_HOURS" default:"24" json:"revocation_ttl_hours"`        // how long revocations are kept, at least the longest token lifetime
+	RevocationFailClosed       bool              `env:"CONFIG__REVOCATION_FAIL_CLOSED" default:"false" json:"revocation_fail_closed"` // refuse tokens while Redis is unreachable
+	AdminToken                 string            `env:"CONFIG__ADMIN_TOKEN" json:"admin_token"`                                       // bearer token for the admin API, the API is disabled when empty
+	RedisDB                    int               `env:"CONFIG__REDIS_DB" default:"0" json:"redis_db"`
 	ProxyURL                   *url.URL
 	APPConfig                  APPConfig
 }
@@ -0,0 +1,25 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/config"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("reads key sources from a JSON list in the environment", func(t *testing.T) {
+		t.Setenv("CONFIG__KEY_SOURCES", `[{"name":"partner","type":"jwks","url":"https://partner.example/jwks.json","audiences":["gateway"],"service_auth":true}]`)
+
+		cfg, err := config.LoadConfig()
+
+		require.NoError(t, err)
+		require.Len(t, cfg.KeySources, 1)
+		assert.Equal(t, "partner", cfg.KeySources[0].Name)
+		assert.Equal(t, "jwks", cfg.KeySources[0].Type)
+		assert.Equal(t, "https://partner.example/jwks.json", cfg.KeySources[0].URL)
+		assert.Equal(t, []string{"gateway"}, cfg.KeySources[0].Audiences)
+		assert.True(t, cfg.KeySources[0].ServiceAuth)
+	})
+}
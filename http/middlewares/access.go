@@ -0,0 +1,87 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/weeb-vip/gateway-proxy/internal/access"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
+	"github.com/weeb-vip/gateway-proxy/internal/graphql"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+)
+
+// maxAccessBodySize bounds the GraphQL bodies read to check access rules
+const maxAccessBodySize = 1 << 20
+
+// Access refuses GraphQL operations the request's token isn't allowed to call, it has to run after Auth
+func Access(policy *access.Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var claims *jwt.ParsedJWT
+			if result, ok := auth.FromContext(r.Context()); ok {
+				claims = result.Claims
+			}
+
+			if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				if err := policy.AuthorizeConnection(claims); err != nil {
+					log := logger.FromCtx(r.Context())
+					log.Warn().Err(err).Msg("Connection refused by access rules")
+					auth.WriteForbidden(w, err)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			bodyBytes, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAccessBodySize))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			// Restore request body for downstream handlers
+			r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+			for _, operation := range operations(r, bodyBytes) {
+				if err := policy.Authorize(operation, claims); err != nil {
+					log := logger.FromCtx(r.Context())
+					event := log.Warn().Err(err)
+					if operation != nil {
+						event = event.Str("operation_type", operation.Type).Str("operation_name", operation.Name)
+					}
+					event.Msg("Operation refused by access rules")
+					auth.WriteForbidden(w, err)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// operations returns one entry per request of a batch, nil for requests that couldn't be parsed
+func operations(r *http.Request, body []byte) []*graphql.Operation {
+	requests, err := graphql.ParseRequests(r, body)
+	if err != nil || len(requests) == 0 {
+		return []*graphql.Operation{nil}
+	}
+
+	result := make([]*graphql.Operation, len(requests))
+	for i, request := range requests {
+		operation, err := request.Operation()
+		if err == nil {
+			result[i] = operation
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,52 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/http/middlewares"
+	"github.com/weeb-vip/gateway-proxy/internal/access"
+)
+
+func TestAccess(t *testing.T) {
+	policy, err := access.NewPolicy(&config.Config{
+		AccessDefault: access.DefaultAllow,
+		AccessRules:   []config.AccessRule{{Fields: []string{"deleteAnime"}, Roles: []string{"admin"}}},
+	})
+	require.NoError(t, err)
+	handler := middlewares.Access(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func(body string) int {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		return recorder.Code
+	}
+
+	t.Run("lets operations no rule refuses through", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(`{"query":"{ anime { id } }"}`))
+	})
+	t.Run("refuses persisted queries sent as a hash only", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send(`{"extensions":{"persistedQuery":{"version":1,"sha256Hash":"abc"}}}`))
+	})
+	t.Run("refuses bodies that don't parse", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send(`{"query":"mutation {"}`))
+	})
+	t.Run("refuses bodies over the size limit", func(t *testing.T) {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, send(`{"query":"{ anime { id } }","padding":"`+strings.Repeat("x", 1<<20)+`"}`))
+	})
+	t.Run("lets WebSocket upgrades through when no wildcard rule refuses them", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+		request.Header.Set("Upgrade", "websocket")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+}
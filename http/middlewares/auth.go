@@ -0,0 +1,180 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/apikey"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+	"github.com/weeb-vip/gateway-proxy/internal/refresh"
+	"github.com/weeb-vip/gateway-proxy/metrics"
+)
+
+type authOptions struct {
+	refresher    *refresh.Refresher
+	apiKeys      *apikey.Authenticator
+	apiKeyHeader string
+	certificates *auth.CertificateAuthenticator
+}
+
+type AuthOption func(*authOptions)
+
+// WithRefresher refreshes expired access token cookies before the request is authenticated again
+func WithRefresher(refresher *refresh.Refresher) AuthOption {
+	return func(o *authOptions) {
+		o.refresher = refresher
+	}
+}
+
+// WithAPIKeys authenticates requests carrying the given header with an API key instead of a token
+func WithAPIKeys(authenticator *apikey.Authenticator, header string) AuthOption {
+	return func(o *authOptions) {
+		o.apiKeys = authenticator
+		o.apiKeyHeader = header
+	}
+}
+
+// WithClientCertificates authenticates requests carrying neither a token nor an API key by their client certificate
+func WithClientCertificates(authenticator *auth.CertificateAuthenticator) AuthOption {
+	return func(o *authOptions) {
+		o.certificates = authenticator
+	}
+}
+
+// Auth verifies the request token once and enforces the configured authentication policy.
+// The result is stored in the request context so the proxy doesn't need to parse the token again.
+func Auth(cfg *config.Config, parser jwt.Parser, extractors auth.Extractors, opts ...AuthOption) func(http.Handler) http.Handler {
+	options := authOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	// only a token read from a cookie can be refreshed at the gateway
+	accessCookie := extractors.CookieName()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var result *auth.Result
+			if key := apiKey(r, options); key != "" {
+				result = authenticateAPIKey(r, options.apiKeys, key)
+				if errors.Is(result.Err, apikey.ErrRateLimited) {
+					auth.WriteRateLimited(w, result.Err)
+					return
+				}
+			} else {
+				start := time.Now()
+				result = auth.Authenticate(r, parser, extractors)
+
+				if options.refresher != nil && refreshable(r, result, accessCookie) {
+					if refreshed, ok := refreshAccessToken(w, r, options.refresher, accessCookie); ok {
+						r = refreshed
+						result = auth.Authenticate(r, parser, extractors)
+					}
+				}
+
+				if !result.Anonymous() {
+					recordJWTValidation(r, result, time.Since(start))
+				}
+			}
+			// a token sent over an mTLS connection acts on behalf of its user, the certificate only applies without one
+			if result.Anonymous() && options.certificates != nil {
+				result = options.certificates.Authenticate(r)
+			}
+
+			if err := auth.Enforce(cfg.AuthEnforcement, result); err != nil {
+				log := logger.FromCtx(r.Context())
+				log.Warn().
+					Err(err).
+					AnErr("token_error", result.Err).
+					Str("enforcement", cfg.AuthEnforcement).
+					Msg("Request rejected by authentication policy")
+				auth.WriteUnauthorized(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithResult(r.Context(), result)))
+		})
+	}
+}
+
+func apiKey(r *http.Request, options authOptions) string {
+	if options.apiKeys == nil {
+		return ""
+	}
+
+	return r.Header.Get(options.apiKeyHeader)
+}
+
+func authenticateAPIKey(r *http.Request, authenticator *apikey.Authenticator, key string) *auth.Result {
+	claims, err := authenticator.Authenticate(r.Context(), key)
+	if err != nil {
+		log := logger.FromCtx(r.Context())
+		log.Debug().Err(err).Msg("API key refused")
+		return &auth.Result{Method: auth.MethodAPIKey, Err: err}
+	}
+
+	return &auth.Result{Method: auth.MethodAPIKey, Claims: claims}
+}
+
+// refreshable reports whether the request carries an expired access token cookie next to a refresh token
+func refreshable(r *http.Request, result *auth.Result, accessCookie string) bool {
+	if accessCookie == "" || jwt.Reason(result.Err) != "expired" {
+		return false
+	}
+	accessToken, err := r.Cookie(accessCookie)
+	if err != nil || accessToken.Value != result.Token {
+		// the expired token came from the Authorization header, the client manages it
+		return false
+	}
+	refreshToken, err := r.Cookie(refresh.RefreshTokenCookie)
+
+	return err == nil && refreshToken.Value != ""
+}
+
+// refreshAccessToken swaps the expired cookie for a fresh one and passes the new cookies on to the client
+func refreshAccessToken(w http.ResponseWriter, r *http.Request, refresher *refresh.Refresher, accessCookie string) (*http.Request, bool) {
+	refreshToken, _ := r.Cookie(refresh.RefreshTokenCookie)
+	tokens, err := refresher.Refresh(r.Context(), refreshToken.Value)
+	if err != nil {
+		log := logger.FromCtx(r.Context())
+		log.Warn().Err(err).Msg("Failed to refresh expired access token")
+		return r, false
+	}
+
+	for _, setCookie := range tokens.SetCookies {
+		w.Header().Add("Set-Cookie", setCookie)
+	}
+
+	refreshed := r.Clone(r.Context())
+	refreshed.Header.Del("Cookie")
+	for _, cookie := range r.Cookies() {
+		switch {
+		case cookie.Name == accessCookie:
+			cookie.Value = tokens.AccessToken
+		case cookie.Name == refresh.RefreshTokenCookie && tokens.RefreshToken != "":
+			cookie.Value = tokens.RefreshToken
+		}
+		refreshed.AddCookie(cookie)
+	}
+
+	return refreshed, true
+}
+
+func recordJWTValidation(r *http.Request, result *auth.Result, duration time.Duration) {
+	success := result.Err == nil
+	userID := ""
+	if success && result.Claims.Subject != nil {
+		userID = *result.Claims.Subject
+	}
+
+	reason := jwt.Reason(result.Err)
+	metrics.GetAppMetrics().JWTValidationMetric(float64(duration.Nanoseconds())/float64(time.Millisecond), reason)
+	logger.LogJWTValidation(r.Context(), success, duration, userID)
+	if !success {
+		log := logger.FromCtx(r.Context())
+		log.Debug().Err(result.Err).Str("reason", reason).Msg("Token refused")
+	}
+}
@@ -0,0 +1,187 @@
+package middlewares_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/http/middlewares"
+	"github.com/weeb-vip/gateway-proxy/internal/apikey"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+	"github.com/weeb-vip/gateway-proxy/internal/refresh"
+)
+
+// tokenParser accepts "access-2" and reports every other token as expired
+type tokenParser struct{}
+
+func (tokenParser) Parse(token string) (*jwt.ParsedJWT, error) {
+	if token == "access-2" {
+		subject := "user-1"
+		return &jwt.ParsedJWT{Subject: &subject}, nil
+	}
+	return nil, &jwtlib.ValidationError{Inner: fmt.Errorf("%w by 1m", jwtlib.ErrTokenExpired), Errors: jwtlib.ValidationErrorExpired}
+}
+
+func TestAuthRefresh(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("refresh_token"); err != nil || cookie.Value != "refresh-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "access_token", Value: "access-2", Path: "/"})
+	}))
+	defer service.Close()
+	cfg := &config.Config{AuthMode: "cookie", AuthEnforcement: auth.EnforcementRequireValid}
+
+	serve := func(request *http.Request) (*httptest.ResponseRecorder, *http.Request) {
+		var forwarded *http.Request
+		handler := middlewares.Auth(cfg, tokenParser{}, auth.DefaultExtractors(cfg.AuthMode), middlewares.WithRefresher(refresh.NewRefresher(service.URL, time.Second)))(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { forwarded = r }),
+		)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder, forwarded
+	}
+
+	t.Run("refreshes an expired access token cookie and forwards the fresh one", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "access-1"})
+		request.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh-1"})
+		request.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+
+		recorder, forwarded := serve(request)
+
+		require.NotNil(t, forwarded)
+		cookie, err := forwarded.Cookie("access_token")
+		require.NoError(t, err)
+		assert.Equal(t, "access-2", cookie.Value)
+		theme, err := forwarded.Cookie("theme")
+		require.NoError(t, err)
+		assert.Equal(t, "dark", theme.Value)
+		result, ok := auth.FromContext(forwarded.Context())
+		require.True(t, ok)
+		assert.Equal(t, "user-1", *result.Claims.Subject)
+		assert.Contains(t, recorder.Header().Get("Set-Cookie"), "access_token=access-2")
+	})
+	t.Run("keeps the expired token when the refresh fails", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "access-1"})
+		request.AddCookie(&http.Cookie{Name: "refresh_token", Value: "revoked"})
+
+		recorder, forwarded := serve(request)
+
+		assert.Nil(t, forwarded)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Empty(t, recorder.Header().Values("Set-Cookie"))
+	})
+	t.Run("doesn't refresh without a refresh token", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "access-1"})
+
+		recorder, _ := serve(request)
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+	t.Run("doesn't refresh tokens sent in the Authorization header", func(t *testing.T) {
+		cfg.AuthMode = "both"
+		defer func() { cfg.AuthMode = "cookie" }()
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set("Authorization", "Bearer access-1")
+		request.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh-1"})
+
+		recorder, _ := serve(request)
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+	t.Run("refreshes the configured access token cookie", func(t *testing.T) {
+		sessionService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "access-2", Path: "/"})
+		}))
+		defer sessionService.Close()
+		extractors, err := auth.NewExtractors([]config.TokenSource{{Type: "cookie", Name: "session"}}, "")
+		require.NoError(t, err)
+		refresher := refresh.NewRefresher(sessionService.URL, time.Second, refresh.WithAccessTokenCookie(extractors.CookieName()))
+		var forwarded *http.Request
+		handler := middlewares.Auth(cfg, tokenParser{}, extractors, middlewares.WithRefresher(refresher))(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { forwarded = r }),
+		)
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.AddCookie(&http.Cookie{Name: "session", Value: "access-1"})
+		request.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh-1"})
+
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		require.NotNil(t, forwarded)
+		cookie, err := forwarded.Cookie("session")
+		require.NoError(t, err)
+		assert.Equal(t, "access-2", cookie.Value)
+	})
+}
+
+type keyStore map[string]*apikey.Record
+
+func (k keyStore) Lookup(_ context.Context, hash string) (*apikey.Record, error) {
+	return k[hash], nil
+}
+
+func (k keyStore) TouchLastUsed(_ context.Context, _ string, _ time.Time) error {
+	return nil
+}
+
+func TestAuthAPIKeys(t *testing.T) {
+	store := keyStore{apikey.Hash("secret-key"): {ID: "importer", Subject: "service:importer", RateLimitPerMinute: 1}}
+	cfg := &config.Config{AuthMode: "both", AuthEnforcement: auth.EnforcementRejectInvalid}
+	handler := func(forwarded **http.Request) http.Handler {
+		authenticator := apikey.NewAuthenticator(store, apikey.NewMemoryLimiter(), 0)
+		return middlewares.Auth(cfg, tokenParser{}, auth.DefaultExtractors(cfg.AuthMode), middlewares.WithAPIKeys(authenticator, "X-API-Key"))(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { *forwarded = r }),
+		)
+	}
+
+	t.Run("authenticates the request as the key's principal", func(t *testing.T) {
+		var forwarded *http.Request
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set("X-API-Key", "secret-key")
+
+		handler(&forwarded).ServeHTTP(httptest.NewRecorder(), request)
+
+		require.NotNil(t, forwarded)
+		result, ok := auth.FromContext(forwarded.Context())
+		require.True(t, ok)
+		assert.Equal(t, auth.MethodAPIKey, result.Method)
+		assert.Equal(t, "service:importer", *result.Claims.Subject)
+		assert.Empty(t, result.Token)
+	})
+	t.Run("rejects unknown keys", func(t *testing.T) {
+		var forwarded *http.Request
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set("X-API-Key", "other-key")
+		recorder := httptest.NewRecorder()
+
+		handler(&forwarded).ServeHTTP(recorder, request)
+
+		assert.Nil(t, forwarded)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+	t.Run("answers 429 once the key's limit is used up", func(t *testing.T) {
+		var forwarded *http.Request
+		h := handler(&forwarded)
+		for _, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			request := httptest.NewRequest(http.MethodPost, "/", nil)
+			request.Header.Set("X-API-Key", "secret-key")
+			recorder := httptest.NewRecorder()
+
+			h.ServeHTTP(recorder, request)
+
+			assert.Equal(t, expected, recorder.Code)
+		}
+	})
+}
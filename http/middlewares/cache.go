@@ -2,13 +2,16 @@ package middlewares
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
 	"github.com/weeb-vip/gateway-proxy/internal/cache"
+	"github.com/weeb-vip/gateway-proxy/internal/graphql"
 	"github.com/weeb-vip/gateway-proxy/internal/logger"
 	"github.com/weeb-vip/gateway-proxy/metrics"
 	"github.com/weeb-vip/gateway-proxy/tracing"
@@ -37,6 +40,11 @@ func (rw *cacheResponseWriter) WriteHeader(status int) {
 	rw.ResponseWriter.WriteHeader(status)
 }
 
+// Unwrap returns the wrapped ResponseWriter
+func (rw *cacheResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func GraphQLCacheMiddleware(cache *cache.GraphQLCache, cfg *config.Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -59,19 +67,17 @@ func GraphQLCacheMiddleware(cache *cache.GraphQLCache, cfg *config.Config) func(
 				return
 			}
 
-			// Extract user token from access_token cookie
-			var userToken string
-			if accessTokenCookie, err := r.Cookie("access_token"); err == nil {
-				userToken = accessTokenCookie.Value
-			}
-
-			// If no user token, don't cache (could be public queries)
-			if userToken == "" {
-				log.Debug().Msg("No user token found, skipping cache")
+			// Only tokens the auth middleware verified reach cached data, a revoked or expired token
+			// must not keep reading its user's entries. Anonymous requests aren't cached (could be public queries)
+			result, ok := auth.FromContext(r.Context())
+			if !ok || result.Claims == nil || result.Token == "" {
+				log.Debug().Msg("No verified user token, skipping cache")
 				metricsClient.CacheCounterMetric("skip_no_token")
+				w.Header().Set("X-Cache-Status", "MISS")
 				next.ServeHTTP(w, r)
 				return
 			}
+			userToken := result.Token
 
 			// Read request body
 			bodyBytes, err := io.ReadAll(r.Body)
@@ -84,8 +90,8 @@ func GraphQLCacheMiddleware(cache *cache.GraphQLCache, cfg *config.Config) func(
 			// Restore request body for downstream handlers
 			r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
-			// Generate cache key
-			cacheKey := cache.GenerateKey(userToken, string(bodyBytes))
+			// Generate cache key from the normalized requests, equivalent requests share an entry
+			cacheKey := cache.GenerateKey(userToken, normalizedBody(r, bodyBytes))
 
 			// Check if response is cached with tracing
 			tracer := tracing.GetTracer(r.Context())
@@ -151,12 +157,11 @@ func GraphQLCacheMiddleware(cache *cache.GraphQLCache, cfg *config.Config) func(
 
 			// Only cache successful responses (2xx status codes)
 			if rw.status >= 200 && rw.status < 300 {
-				// Check if response is cacheable (avoid caching mutations)
-				requestBody := string(bodyBytes)
-				if !isCacheableRequest(requestBody) {
+				// Check if response is cacheable (avoid caching mutations and subscriptions)
+				if !isCacheableRequest(r, bodyBytes) {
 					log.Debug().
 						Str("cache_key", cacheKey).
-						Msg("Request not cacheable (likely mutation)")
+						Msg("Request not cacheable (not a query)")
 					metricsClient.CacheCounterMetric("skip_mutation")
 					w.Header().Set("X-Cache-Status", "SKIP")
 					return
@@ -174,22 +179,43 @@ func GraphQLCacheMiddleware(cache *cache.GraphQLCache, cfg *config.Config) func(
 	}
 }
 
-// isCacheableRequest determines if a GraphQL request should be cached
-// Generally, we only want to cache queries, not mutations
-func isCacheableRequest(requestBody string) bool {
-	// Convert to lowercase for case-insensitive matching
-	body := strings.ToLower(requestBody)
+// normalizedBody renders the GraphQL requests of body in their canonical form.
+// Bodies that don't parse, like persisted queries without a document, fall back to the raw bytes
+func normalizedBody(r *http.Request, body []byte) string {
+	requests, err := graphql.ParseRequests(r, body)
+	if err != nil {
+		return string(body)
+	}
 
-	// If it contains "mutation", it's likely not cacheable
-	if strings.Contains(body, "mutation") {
+	normalized := make([]string, len(requests))
+	for i, request := range requests {
+		if normalized[i], err = request.Normalize(); err != nil {
+			return string(body)
+		}
+	}
+	// a batch of one is answered with an array, it mustn't share the entry of the single request
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		batch, _ := json.Marshal(normalized)
+		return "batch:" + string(batch)
+	}
+
+	return normalized[0]
+}
+
+// isCacheableRequest determines if a GraphQL request should be cached.
+// Only queries are, a batch is cacheable when all of its operations are queries
+func isCacheableRequest(r *http.Request, body []byte) bool {
+	requests, err := graphql.ParseRequests(r, body)
+	if err != nil || len(requests) == 0 {
 		return false
 	}
 
-	// If it contains "query" or looks like a query, it's probably cacheable
-	if strings.Contains(body, "query") || strings.Contains(body, "{") {
-		return true
+	for _, request := range requests {
+		operation, err := request.Operation()
+		if err != nil || operation.Type != graphql.OperationQuery {
+			return false
+		}
 	}
 
-	// Default to not caching if we can't determine
-	return false
-}
\ No newline at end of file
+	return true
+}
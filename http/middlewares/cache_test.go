@@ -0,0 +1,116 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/http/middlewares"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
+	"github.com/weeb-vip/gateway-proxy/internal/cache"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+)
+
+// revocableParser accepts every token that isn't revoked
+type revocableParser map[string]bool
+
+func (r revocableParser) Parse(token string) (*jwt.ParsedJWT, error) {
+	if r[token] {
+		return nil, jwt.ErrTokenRevoked
+	}
+	subject := "user-1"
+	return &jwt.ParsedJWT{Subject: &subject}, nil
+}
+
+func TestGraphQLCacheMiddleware(t *testing.T) {
+	cfg := &config.Config{RedisURL: "redis://" + miniredis.RunT(t).Addr(), AuthEnforcement: auth.EnforcementOff}
+	graphQLCache, err := cache.NewGraphQLCache(cfg, time.Minute)
+	require.NoError(t, err)
+	upstreamCalls := 0
+	revoked := revocableParser{}
+	// the cache runs behind the auth middleware, like in the server
+	handler := middlewares.Auth(cfg, revoked, auth.DefaultExtractors("header"))(middlewares.GraphQLCacheMiddleware(graphQLCache, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		_, _ = w.Write([]byte(`{"data":{}}`))
+	})))
+	sendWith := func(token string, contentType string, body string) string {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		return recorder.Header().Get("X-Cache-Status")
+	}
+	sendAs := func(contentType string, body string) string {
+		return sendWith("token", contentType, body)
+	}
+	send := func(body string) string {
+		return sendAs("application/json", body)
+	}
+
+	t.Run("serves equivalent requests from the same entry", func(t *testing.T) {
+		assert.Equal(t, "MISS", send(`{"query":"query User($id: ID!) { user(id: $id) { id name } }","variables":{"id":"1","locale":"en"}}`))
+		assert.Equal(t, "HIT", send(`{
+			"variables": {"locale": "en", "id": "1"},
+			"query": "# fetch the user\nquery User($id: ID!) {\n  user(id: $id) {\n    id\n    name\n  }\n}"
+		}`))
+		assert.Equal(t, 1, upstreamCalls)
+	})
+	t.Run("keeps different requests apart", func(t *testing.T) {
+		upstreamCalls = 0
+		assert.Equal(t, "MISS", send(`{"query":"query User($id: ID!) { user(id: $id) { id name } }","variables":{"id":"2","locale":"en"}}`))
+		assert.Equal(t, "MISS", send(`{"query":"query User($id: ID!) { user(id: $id) { id } }","variables":{"id":"1","locale":"en"}}`))
+		assert.Equal(t, "MISS", send(`[{"query":"query User($id: ID!) { user(id: $id) { id name } }","variables":{"id":"1","locale":"en"}}]`))
+		assert.Equal(t, 3, upstreamCalls)
+	})
+	t.Run("caches queries whatever words they contain", func(t *testing.T) {
+		body := `{"query":"query History($kind: String) { mutationHistory(kind: $kind) { id } }","variables":{"kind":"mutation"}}`
+		assert.Equal(t, "MISS", send(body))
+		assert.Equal(t, "HIT", send(body))
+	})
+	t.Run("caches application/graphql queries", func(t *testing.T) {
+		assert.Equal(t, "MISS", sendAs("application/graphql", `{ posts { id } }`))
+		assert.Equal(t, "HIT", sendAs("application/graphql", `query { posts { id } }`))
+	})
+	t.Run("caches the operation selected by its name", func(t *testing.T) {
+		body := `{"query":"mutation Logout { logout } query Me { me { id } }","operationName":"Me"}`
+		assert.Equal(t, "MISS", send(body))
+		assert.Equal(t, "HIT", send(body))
+	})
+	t.Run("never caches mutations or subscriptions", func(t *testing.T) {
+		for _, body := range []string{
+			`{"query":"mutation { logout }"}`,
+			`{"query":"subscription { messages { id } }"}`,
+			`{"query":"mutation Logout { logout } query Me { me { id } }","operationName":"Logout"}`,
+			`[{"query":"{ me { id } }"},{"query":"mutation { logout }"}]`,
+		} {
+			assert.Equal(t, "SKIP", send(body), body)
+			assert.Equal(t, "SKIP", send(body), body)
+		}
+	})
+	t.Run("caches batches of queries", func(t *testing.T) {
+		body := `[{"query":"{ me { id } }"},{"query":"{ posts { id } }"}]`
+		assert.Equal(t, "MISS", send(body))
+		assert.Equal(t, "HIT", send(body))
+	})
+	t.Run("doesn't cache documents that don't parse", func(t *testing.T) {
+		assert.Equal(t, "SKIP", send(`{"query":"query {"}`))
+		assert.Equal(t, "SKIP", send(`{"query":"query {"}`))
+	})
+	t.Run("revoked tokens no longer read their cached entries", func(t *testing.T) {
+		query := `{"query":"{ me { id email } }"}`
+		assert.Equal(t, "MISS", sendWith("revocable", "application/json", query))
+		assert.Equal(t, "HIT", sendWith("revocable", "application/json", query))
+
+		revoked["revocable"] = true
+
+		assert.Equal(t, "MISS", sendWith("revocable", "application/json", query))
+	})
+}
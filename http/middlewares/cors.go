@@ -68,6 +68,11 @@ func (c *corsResponseWriter) Write(data []byte) (int, error) {
 	return c.ResponseWriter.Write(data)
 }
 
+// Unwrap gives http.ResponseController the wrapped writer, needed to hijack upgrades
+func (c *corsResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
 func setCORSHeaders(w http.ResponseWriter, origin string, cfg *config.Config) {
 	// Check if origin is allowed
 	if isOriginAllowed(origin, cfg.CORSAllowedOrigins) {
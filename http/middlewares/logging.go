@@ -23,6 +23,11 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the connection, WebSocket upgrades hijack it
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
 // Logger logs request URL and response code
 func Logger() func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
@@ -22,6 +22,11 @@ func (mrw *metricsResponseWriter) WriteHeader(code int) {
 	mrw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap exposes the underlying writer, the proxy hijacks it for protocol upgrades
+func (mrw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mrw.ResponseWriter
+}
+
 // MetricsMiddleware tracks HTTP request metrics
 func MetricsMiddleware() func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
@@ -0,0 +1,83 @@
+package middlewares_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/http/middlewares"
+	"github.com/weeb-vip/gateway-proxy/internal/access"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
+	"github.com/weeb-vip/gateway-proxy/internal/handlers"
+)
+
+func TestWebSocketUpgrade(t *testing.T) {
+	// the upstream switches protocols and echoes what it reads
+	var forwarded *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+		conn, buffered, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		_, _ = buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = buffered.Flush()
+		_, _ = io.Copy(conn, buffered)
+	}))
+	defer upstream.Close()
+	proxyURL, _ := url.Parse(upstream.URL)
+	cfg := &config.Config{ProxyURL: proxyURL, AuthEnforcement: auth.EnforcementRequireValid}
+	extractors, err := auth.NewExtractors([]config.TokenSource{{Type: "query"}}, "")
+	require.NoError(t, err)
+	policy, err := access.NewPolicy(&config.Config{AccessDefault: access.DefaultAllow})
+	require.NoError(t, err)
+
+	// the chain the server builds, innermost first
+	var handler http.Handler = handlers.GetProxy(cfg, tokenParser{}, handlers.WithExtractors(extractors))
+	handler = middlewares.Logger()(handler)
+	handler = middlewares.MetricsMiddleware()(handler)
+	handler = middlewares.Tracer()(handler)
+	handler = middlewares.Access(policy)(handler)
+	handler = middlewares.Auth(cfg, tokenParser{}, extractors)(handler)
+	handler = middlewares.CORS(cfg)(handler)
+	gateway := httptest.NewServer(handler)
+	defer gateway.Close()
+
+	t.Run("switches protocols through every middleware", func(t *testing.T) {
+		conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		request, err := http.NewRequest(http.MethodGet, gateway.URL+"/graphql?access_token=access-2", nil)
+		require.NoError(t, err)
+		request.Header.Set("Connection", "Upgrade")
+		request.Header.Set("Upgrade", "websocket")
+		request.Header.Set("Sec-WebSocket-Version", "13")
+		request.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		require.NoError(t, request.Write(conn))
+
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, request)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusSwitchingProtocols, response.StatusCode)
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		echoed := make([]byte, 4)
+		_, err = io.ReadFull(reader, echoed)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(echoed))
+
+		require.NotNil(t, forwarded)
+		assert.Equal(t, "user-1", forwarded.Header.Get("x-user-id"))
+		assert.Empty(t, forwarded.URL.Query().Get("access_token"))
+	})
+}
@@ -4,24 +4,41 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"github.com/weeb-vip/gateway-proxy/config"
 	"github.com/weeb-vip/gateway-proxy/http/middlewares"
+	"github.com/weeb-vip/gateway-proxy/internal/access"
+	"github.com/weeb-vip/gateway-proxy/internal/apikey"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
 	"github.com/weeb-vip/gateway-proxy/internal/cache"
 	"github.com/weeb-vip/gateway-proxy/internal/handlers"
+	"github.com/weeb-vip/gateway-proxy/internal/health"
+	"github.com/weeb-vip/gateway-proxy/internal/identity"
 	"github.com/weeb-vip/gateway-proxy/internal/jwt"
 	"github.com/weeb-vip/gateway-proxy/internal/keys"
 	"github.com/weeb-vip/gateway-proxy/internal/logger"
 	"github.com/weeb-vip/gateway-proxy/internal/poller"
+	"github.com/weeb-vip/gateway-proxy/internal/refresh"
+	"github.com/weeb-vip/gateway-proxy/internal/revocation"
+	"github.com/weeb-vip/gateway-proxy/internal/servertls"
 	"github.com/weeb-vip/gateway-proxy/metrics"
 	"github.com/weeb-vip/gateway-proxy/tracing"
 )
 
 func Start(cfg *config.Config, formatter logrus.Formatter) error {
-	// Initialize context
-	ctx := context.Background()
+	if err := auth.ValidateEnforcement(cfg.AuthEnforcement); err != nil {
+		return err
+	}
+
+	// Initialize context, cancelled on SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Initialize structured logging
 	logger.Logger(
@@ -43,7 +60,10 @@ func Start(cfg *config.Config, formatter logrus.Formatter) error {
 	// Setup graceful shutdown for tracing
 	defer func() {
 		log.Info().Msg("Shutting down tracing...")
-		if shutdownErr := tracing.Shutdown(context.Background()); shutdownErr != nil {
+		// bounded, an unreachable collector must not outlast the termination grace period
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if shutdownErr := tracing.Shutdown(shutdownCtx); shutdownErr != nil {
 			log.Error().Err(shutdownErr).Msg("Error shutting down tracing")
 		}
 	}()
@@ -51,10 +71,66 @@ func Start(cfg *config.Config, formatter logrus.Formatter) error {
 	// Keep logrus for compatibility
 	logrus.SetFormatter(formatter)
 
-	jwtParser, err := getJWTParser(cfg)
+	mux := http.NewServeMux()
+
+	// Create cache if enabled
+	var graphqlCache *cache.GraphQLCache
+	if cfg.CacheEnabled {
+		cacheTTL := time.Duration(cfg.CacheTTLMinutes) * time.Minute
+		var err error
+		graphqlCache, err = cache.NewGraphQLCache(cfg, cacheTTL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to initialize Redis cache")
+			return fmt.Errorf("failed to initialize Redis cache: %w", err)
+		}
+		log.Info().
+			Dur("ttl", cacheTTL).
+			Msg("GraphQL Redis cache enabled")
+
+		// Setup graceful shutdown for Redis
+		defer func() {
+			if graphqlCache != nil {
+				if closeErr := graphqlCache.Close(); closeErr != nil {
+					log.Error().Err(closeErr).Msg("Error closing Redis connection")
+				}
+			}
+		}()
+	}
+
+	// features backed by Redis share one connection, the cache's when it is enabled
+	var redisClient *redis.Client
+	if graphqlCache != nil {
+		redisClient = graphqlCache.Client()
+	} else if cfg.RevocationEnabled || (cfg.APIKeysEnabled && cfg.APIKeyStore == apikey.StoreRedis) {
+		redisClient, err = cache.NewRedisClient(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to initialize Redis connection: %w", err)
+		}
+		defer redisClient.Close()
+	}
+
+	var parserOptions []jwt.Option
+	if cfg.RevocationEnabled {
+		store := revocation.NewStore(redisClient,
+			revocation.WithCache(cfg.RevocationCacheSize, time.Duration(cfg.RevocationCacheSeconds)*time.Second),
+			revocation.WithMaxTokenLifetime(time.Duration(cfg.RevocationTTLHours)*time.Hour),
+			revocation.WithFailClosed(cfg.RevocationFailClosed),
+		)
+		parserOptions = append(parserOptions, jwt.WithRevocationChecker(store))
+		log.Info().Msg("Token revocation checks enabled")
+
+		if cfg.AdminToken != "" {
+			mux.Handle("/admin/revocations", revocation.AdminHandler(store, cfg.AdminToken))
+			log.Info().Msg("Revocation admin API available at /admin/revocations")
+		}
+	}
+
+	keyManager, err := getKeyManager(ctx, cfg)
 	if err != nil {
 		return err
 	}
+	defer keyManager.Stop()
+	jwtParser := getJWTParser(cfg, keyManager, parserOptions...)
 
 	log.Info().
 		Str("proxy_host", cfg.ProxyURL.Host).
@@ -67,7 +143,19 @@ func Start(cfg *config.Config, formatter logrus.Formatter) error {
 	fmt.Printf("proxy requests to: %s\n", cfg.ProxyURL.Host)
 	fmt.Println(fmt.Sprintf("listening on http://localhost:%d", cfg.Port))
 
-	mux := http.NewServeMux()
+	readinessChecks := []health.Option{
+		health.WithCheck("keys", health.KeysCheck(keyManager, time.Duration(cfg.ReadyKeysMaxAgeMinutes)*time.Minute)),
+	}
+	if graphqlCache != nil {
+		readinessChecks = append(readinessChecks, health.WithCheck("redis", health.RedisCheck(redisClient)))
+	}
+	if cfg.ReadyUpstreamURL != "" {
+		readinessChecks = append(readinessChecks, health.WithCheck("upstream", health.UpstreamCheck(cfg.ReadyUpstreamURL)))
+	}
+	readiness := health.NewReadiness(readinessChecks...)
+	mux.Handle("/healthz", health.Liveness())
+	mux.Handle("/readyz", readiness.Handler())
+	log.Info().Int("checks", len(readinessChecks)).Msg("Health endpoints available at /healthz and /readyz")
 
 	// Add metrics endpoint for Prometheus scraping
 	_ = metrics.GetAppMetrics() // Initialize metrics
@@ -76,32 +164,26 @@ func Start(cfg *config.Config, formatter logrus.Formatter) error {
 		log.Info().Msg("Metrics endpoint available at /metrics")
 	}
 
-	// Create cache if enabled
-	var graphqlCache *cache.GraphQLCache
-	if cfg.CacheEnabled {
-		cacheTTL := time.Duration(cfg.CacheTTLMinutes) * time.Minute
-		var err error
-		graphqlCache, err = cache.NewGraphQLCache(cfg, cacheTTL)
+	var proxyOptions []handlers.ProxyOption
+	if cfg.InternalTokenEnabled {
+		minter, err := identity.NewMinter(cfg)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to initialize Redis cache")
-			return fmt.Errorf("failed to initialize Redis cache: %w", err)
+			return err
 		}
-		log.Info().
-			Dur("ttl", cacheTTL).
-			Msg("GraphQL Redis cache enabled")
+		proxyOptions = append(proxyOptions, handlers.WithIdentityMinter(minter))
+		mux.Handle("/.well-known/jwks.json", minter.JWKSHandler())
+		log.Info().Msg("Internal identity tokens enabled, public key available at /.well-known/jwks.json")
+	}
 
-		// Setup graceful shutdown for Redis
-		defer func() {
-			if graphqlCache != nil {
-				if closeErr := graphqlCache.Close(); closeErr != nil {
-					log.Error().Err(closeErr).Msg("Error closing Redis connection")
-				}
-			}
-		}()
+	// the auth middleware, the cache and the proxy have to agree on the token a request carries
+	extractors, err := auth.NewExtractors(cfg.TokenSources, cfg.AuthMode)
+	if err != nil {
+		return err
 	}
+	proxyOptions = append(proxyOptions, handlers.WithExtractors(extractors))
 
 	// Build middleware chain
-	var handler http.Handler = handlers.GetProxy(cfg, jwtParser)
+	var handler http.Handler = handlers.GetProxy(cfg, jwtParser, proxyOptions...)
 
 	// Add middlewares in reverse order (innermost first)
 	handler = middlewares.Logger()(handler)
@@ -113,6 +195,44 @@ func Start(cfg *config.Config, formatter logrus.Formatter) error {
 		handler = middlewares.GraphQLCacheMiddleware(graphqlCache, cfg)(handler)
 	}
 
+	policy, err := access.NewPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	if policy.Enabled() {
+		handler = middlewares.Access(policy)(handler)
+	}
+
+	var authOptions []middlewares.AuthOption
+	if cfg.RefreshEndpoint != "" {
+		refresher := refresh.NewRefresher(cfg.RefreshEndpoint, time.Duration(cfg.RefreshTimeoutSeconds)*time.Second,
+			refresh.WithAccessTokenCookie(extractors.CookieName()),
+		)
+		authOptions = append(authOptions, middlewares.WithRefresher(refresher))
+		log.Info().Str("endpoint", cfg.RefreshEndpoint).Msg("Expired access tokens are refreshed at the gateway")
+	}
+
+	if cfg.APIKeysEnabled {
+		authenticator, err := getAPIKeyAuthenticator(cfg, redisClient)
+		if err != nil {
+			return err
+		}
+		authOptions = append(authOptions, middlewares.WithAPIKeys(authenticator, cfg.APIKeyHeader))
+		log.Info().Str("header", cfg.APIKeyHeader).Str("store", cfg.APIKeyStore).Msg("API key authentication enabled")
+	}
+
+	if cfg.TLSEnabled && cfg.TLSClientCAFile != "" {
+		certificates, err := auth.NewCertificateAuthenticator(cfg.MTLSPrincipal)
+		if err != nil {
+			return err
+		}
+		authOptions = append(authOptions, middlewares.WithClientCertificates(certificates))
+		log.Info().Str("principal", cfg.MTLSPrincipal).Msg("Client certificate authentication enabled")
+	}
+
+	// Enforce authentication before anything is served, including cached responses
+	handler = middlewares.Auth(cfg, jwtParser, extractors, authOptions...)(handler)
+
 	handler = middlewares.CORS(cfg)(handler)
 
 	mux.Handle("/", handler)
@@ -120,7 +240,26 @@ func Start(cfg *config.Config, formatter logrus.Formatter) error {
 	// Use traced context for the server (although http.ListenAndServe doesn't directly use it)
 	_ = tracedCtx
 
-	return http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), mux)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: mux}
+	if cfg.TLSEnabled {
+		// stops watching the certificate files once shutdown begins
+		server.TLSConfig, err = servertls.New(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		log.Info().
+			Str("min_version", cfg.TLSMinVersion).
+			Int("reload_seconds", cfg.TLSReloadSeconds).
+			Bool("client_certificates", cfg.TLSClientCAFile != "").
+			Msg("Serving HTTPS")
+	}
+
+	// the deferred calls above stop the key poller, close Redis and flush traces, in that order, once serve returns.
+	// Metrics need no flush step, Prometheus pulls them from /metrics and nothing is buffered in the process
+	return serve(ctx, stop, server, readiness,
+		time.Duration(cfg.ShutdownDelaySeconds)*time.Second,
+		time.Duration(cfg.ShutdownDrainSeconds)*time.Second,
+	)
 }
 
 func getMinimumDuration(askedDuration time.Duration, minimumDuration time.Duration) time.Duration {
@@ -131,16 +270,123 @@ func getMinimumDuration(askedDuration time.Duration, minimumDuration time.Durati
 	return askedDuration
 }
 
-func getJWTParser(cfg *config.Config) (jwt.Parser, error) {
-	fetcher := keys.NewFetcher(cfg.GraphQLEndpoint)
+func getKeyManager(ctx context.Context, cfg *config.Config) (poller.KeyManager, error) {
+	sources, err := getKeySources(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	p, err := poller.Keys(fetcher)
+	p, err := poller.MultiSource(sources,
+		poller.WithRefreshInterval(time.Duration(cfg.KeysRefreshIntervalSeconds)*time.Second),
+		poller.WithSnapshot(cfg.KeySnapshotFile, time.Duration(cfg.KeySnapshotMaxAgeHours)*time.Hour),
+		poller.WithKeyRetirement(time.Duration(cfg.KeyRetirementMinutes)*time.Minute),
+	)
 	if err != nil {
 		return nil, err
 	}
 
 	requestedDuration := time.Duration(cfg.KeysPollingDurationMinutes) * time.Minute
-	p.SetupBackgroundPolling(getMinimumDuration(requestedDuration, time.Minute))
+	p.SetupBackgroundPolling(ctx, getMinimumDuration(requestedDuration, time.Minute))
+
+	return p, nil
+}
+
+func getJWTParser(cfg *config.Config, keyManager poller.KeyManager, opts ...jwt.Option) jwt.Parser {
+	options := []jwt.Option{
+		jwt.WithAllowedAlgorithms(cfg.JWTAllowedAlgorithms...),
+		jwt.WithIssuers(cfg.JWTIssuers...),
+		jwt.WithAudiences(cfg.JWTAudiences...),
+		jwt.WithLeeway(time.Duration(cfg.JWTLeewaySeconds) * time.Second),
+		jwt.WithTokenCache(cfg.JWTCacheSize),
+	}
+
+	return jwt.NewParser(keyManager, append(options, opts...)...)
+}
+
+func getAPIKeyAuthenticator(cfg *config.Config, redisClient *redis.Client) (*apikey.Authenticator, error) {
+	switch cfg.APIKeyStore {
+	case apikey.StoreRedis:
+		return apikey.NewAuthenticator(apikey.NewRedisStore(redisClient), apikey.NewRedisLimiter(redisClient), cfg.APIKeyRateLimit), nil
+	case apikey.StoreFile:
+		store, err := apikey.NewFileStore(cfg.APIKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load API keys: %w", err)
+		}
+		// without Redis every replica counts on its own
+		var limiter apikey.Limiter = apikey.NewMemoryLimiter()
+		if redisClient != nil {
+			limiter = apikey.NewRedisLimiter(redisClient)
+		}
+		return apikey.NewAuthenticator(store, limiter, cfg.APIKeyRateLimit), nil
+	default:
+		return nil, fmt.Errorf("unknown API key store %q", cfg.APIKeyStore)
+	}
+}
+
+func getKeySources(cfg *config.Config) ([]poller.Source, error) {
+	tlsConfig, err := servertls.KeyService(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// the service token and client certificate only go to sources run by the key service
+	serviceOptions := []keys.FetcherOption{
+		keys.WithTimeout(time.Duration(cfg.KeyServiceTimeoutSeconds) * time.Second),
+		keys.WithServiceToken(cfg.KeyServiceToken),
+	}
+	fetcherOptions := []keys.FetcherOption{
+		keys.WithTimeout(time.Duration(cfg.KeyServiceTimeoutSeconds) * time.Second),
+	}
+	if tlsConfig != nil {
+		serviceOptions = append(serviceOptions, keys.WithTLSConfig(tlsConfig))
+		withoutCertificate := tlsConfig.Clone()
+		withoutCertificate.Certificates = nil
+		fetcherOptions = append(fetcherOptions, keys.WithTLSConfig(withoutCertificate))
+	}
+
+	if len(cfg.KeySources) == 0 {
+		return []poller.Source{{Name: "key-management", Fetcher: keys.NewFetcher(cfg.GraphQLEndpoint, serviceOptions...)}}, nil
+	}
+
+	sources := make([]poller.Source, 0, len(cfg.KeySources))
+	for i, keySource := range cfg.KeySources {
+		name := keySource.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", keySource.Type, i)
+		}
+
+		var fetcher keys.Fetcher
+		switch keySource.Type {
+		case "graphql":
+			endpoint := keySource.URL
+			if endpoint == "" {
+				endpoint = cfg.GraphQLEndpoint
+			}
+			options := fetcherOptions
+			if keySource.ServiceAuth {
+				options = serviceOptions
+			}
+			fetcher = keys.NewFetcher(endpoint, options...)
+		case "jwks":
+			fetcher = keys.NewJWKSFetcher(keySource.URL, fetcherOptions...)
+		case "pem":
+			fetcher = keys.NewPEMFileFetcher(keySource.Files...)
+		default:
+			return nil, fmt.Errorf("unknown key source type %q for %s", keySource.Type, name)
+		}
+
+		var pollDuration time.Duration
+		if keySource.PollingDurationMinutes > 0 {
+			pollDuration = getMinimumDuration(time.Duration(keySource.PollingDurationMinutes)*time.Minute, time.Minute)
+		}
+
+		sources = append(sources, poller.Source{
+			Name:         name,
+			Issuer:       keySource.Issuer,
+			Audiences:    keySource.Audiences,
+			Fetcher:      fetcher,
+			PollDuration: pollDuration,
+		})
+	}
 
-	return jwt.NewParser(p), nil
+	return sources, nil
 }
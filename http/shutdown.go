@@ -0,0 +1,53 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/internal/health"
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+)
+
+// serve runs server until ctx is done, then shuts it down without cutting requests short:
+// readiness fails first so load balancers stop routing here, after delay the listener closes
+// and in-flight requests get up to drain to complete.
+// stop releases the signals behind ctx, so a second signal kills the process instead of waiting for the drain
+func serve(ctx context.Context, stop context.CancelFunc, server *http.Server, readiness *health.Readiness, delay time.Duration, drain time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			errs <- server.ListenAndServeTLS("", "")
+			return
+		}
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		stop()
+	}
+
+	log := logger.Get()
+	log.Info().Dur("delay", delay).Dur("drain", drain).Msg("Shutdown requested, draining connections")
+	readiness.Drain()
+	time.Sleep(delay)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		// requests still running past the drain timeout are cut
+		_ = server.Close()
+		return fmt.Errorf("failed to drain connections: %w", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Info().Msg("Server stopped")
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/graphql"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+)
+
+func (e *DeniedError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("not authorized to perform this operation: %s", e.Reason)
+	}
+
+	return fmt.Sprintf("not authorized to access %q: %s", e.Field, e.Reason)
+}
+
+func (e *DeniedError) Unwrap() error {
+	return ErrForbidden
+}
+
+// Authorize checks every root field of the operation, claims is nil for anonymous requests.
+// A nil operation stands for a request the gateway couldn't resolve, like a persisted query only sent as a hash.
+// Any rule naming types, operations or fields might apply to it, so it is refused, otherwise wildcard rules apply.
+func (p *Policy) Authorize(operation *graphql.Operation, claims *jwt.ParsedJWT) error {
+	if operation == nil {
+		if p.targeted {
+			return &DeniedError{Reason: "operation couldn't be resolved"}
+		}
+		return p.authorizeField("", "", "", claims)
+	}
+	for _, field := range operation.RootFields {
+		if err := p.authorizeField(operation.Type, operation.Name, field, claims); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AuthorizeConnection checks a WebSocket upgrade, its operations travel over the socket and only wildcard rules apply
+func (p *Policy) AuthorizeConnection(claims *jwt.ParsedJWT) error {
+	return p.authorizeField("", "", "", claims)
+}
+
+func (p *Policy) authorizeField(operationType string, operationName string, field string, claims *jwt.ParsedJWT) error {
+	for _, rule := range p.rules {
+		if !matches(rule.Types, operationType) || !matches(rule.Operations, operationName) || !matches(rule.Fields, field) {
+			continue
+		}
+		if reason := p.unmetRequirement(rule, claims); reason != "" {
+			return &DeniedError{Field: field, Reason: reason}
+		}
+		return nil
+	}
+
+	if !p.defaultAllow {
+		return &DeniedError{Field: field, Reason: "no rule allows it"}
+	}
+
+	return nil
+}
+
+// unmetRequirement returns why the token doesn't satisfy the rule, empty when it does
+func (p *Policy) unmetRequirement(rule config.AccessRule, claims *jwt.ParsedJWT) string {
+	if len(rule.Purposes) > 0 {
+		if claims == nil || claims.Purpose == nil || !contains(rule.Purposes, *claims.Purpose) {
+			return "token purpose not allowed"
+		}
+	}
+	if len(rule.Scopes) > 0 {
+		scopes := claimValues(claims, p.scopesClaim)
+		for _, scope := range rule.Scopes {
+			if !contains(scopes, scope) {
+				return fmt.Sprintf("missing scope %q", scope)
+			}
+		}
+	}
+	if len(rule.Roles) > 0 {
+		roles := claimValues(claims, p.rolesClaim)
+		found := false
+		for _, role := range rule.Roles {
+			if contains(roles, role) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "missing role"
+		}
+	}
+
+	return ""
+}
+
+// claimValues reads a claim holding either a space separated string or a list of strings
+func claimValues(claims *jwt.ParsedJWT, path string) []string {
+	if claims == nil {
+		return nil
+	}
+	value, ok := claims.Claim(path)
+	if !ok {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
+
+func matches(patterns []string, value string) bool {
+	return len(patterns) == 0 || contains(patterns, wildcard) || (value != "" && contains(patterns, value))
+}
+
+func isWildcard(patterns []string) bool {
+	return len(patterns) == 0 || contains(patterns, wildcard)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Enabled reports whether the policy can refuse anything at all
+func (p *Policy) Enabled() bool {
+	return len(p.rules) > 0 || !p.defaultAllow
+}
+
+func NewPolicy(cfg *config.Config) (*Policy, error) {
+	if cfg.AccessDefault != DefaultAllow && cfg.AccessDefault != DefaultDeny {
+		return nil, fmt.Errorf("unknown access default %q, expected %q or %q", cfg.AccessDefault, DefaultAllow, DefaultDeny)
+	}
+
+	targeted := false
+	for _, rule := range cfg.AccessRules {
+		if !isWildcard(rule.Types) || !isWildcard(rule.Operations) || !isWildcard(rule.Fields) {
+			targeted = true
+		}
+	}
+
+	return &Policy{
+		rules:        cfg.AccessRules,
+		targeted:     targeted,
+		defaultAllow: cfg.AccessDefault == DefaultAllow,
+		rolesClaim:   cfg.AccessRolesClaim,
+		scopesClaim:  cfg.AccessScopesClaim,
+	}, nil
+}
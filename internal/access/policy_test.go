@@ -0,0 +1,112 @@
+package access_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/access"
+	"github.com/weeb-vip/gateway-proxy/internal/graphql"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+)
+
+func getPointer[T any](input T) *T {
+	return &input
+}
+
+func newPolicy(t *testing.T, defaultPolicy string, rules ...config.AccessRule) *access.Policy {
+	policy, err := access.NewPolicy(&config.Config{
+		AccessRules:       rules,
+		AccessDefault:     defaultPolicy,
+		AccessRolesClaim:  "realm_access.roles",
+		AccessScopesClaim: "scope",
+	})
+	require.NoError(t, err)
+
+	return policy
+}
+
+func TestPolicy(t *testing.T) {
+	resetToken := &jwt.ParsedJWT{Purpose: getPointer("password_reset")}
+	accessToken := &jwt.ParsedJWT{
+		Purpose: getPointer("access"),
+		Claims: map[string]any{
+			"scope":        "anime:read anime:write",
+			"realm_access": map[string]any{"roles": []any{"editor"}},
+		},
+	}
+	resetPassword := &graphql.Operation{Type: "mutation", RootFields: []string{"resetPassword"}}
+	updateAnime := &graphql.Operation{Type: "mutation", Name: "Update", RootFields: []string{"updateAnime"}}
+
+	// a password reset token may only reset the password, everything else needs an access token
+	policy := newPolicy(t, access.DefaultAllow,
+		config.AccessRule{Fields: []string{"resetPassword"}, Purposes: []string{"password_reset"}},
+		config.AccessRule{Fields: []string{"*"}, Purposes: []string{"access"}},
+	)
+
+	t.Run("allows fields the token purpose is allowed for", func(t *testing.T) {
+		assert.NoError(t, policy.Authorize(resetPassword, resetToken))
+		assert.NoError(t, policy.Authorize(updateAnime, accessToken))
+	})
+	t.Run("refuses fields the token purpose isn't allowed for", func(t *testing.T) {
+		err := policy.Authorize(updateAnime, resetToken)
+
+		assert.ErrorIs(t, err, access.ErrForbidden)
+		assert.EqualError(t, err, `not authorized to access "updateAnime": token purpose not allowed`)
+		assert.ErrorIs(t, policy.Authorize(resetPassword, accessToken), access.ErrForbidden)
+	})
+	t.Run("refuses anonymous requests when a requirement is set", func(t *testing.T) {
+		assert.ErrorIs(t, policy.Authorize(updateAnime, nil), access.ErrForbidden)
+	})
+	t.Run("refuses operations that couldn't be resolved when a rule names fields", func(t *testing.T) {
+		err := policy.Authorize(nil, accessToken)
+
+		assert.ErrorIs(t, err, access.ErrForbidden)
+		assert.EqualError(t, err, "not authorized to perform this operation: operation couldn't be resolved")
+	})
+	t.Run("applies wildcard rules to operations that couldn't be resolved", func(t *testing.T) {
+		policy := newPolicy(t, access.DefaultAllow, config.AccessRule{Fields: []string{"*"}, Purposes: []string{"access"}})
+
+		assert.ErrorIs(t, policy.Authorize(nil, resetToken), access.ErrForbidden)
+		assert.NoError(t, policy.Authorize(nil, accessToken))
+	})
+	t.Run("applies wildcard rules to WebSocket connections", func(t *testing.T) {
+		assert.ErrorIs(t, policy.AuthorizeConnection(resetToken), access.ErrForbidden)
+		assert.NoError(t, policy.AuthorizeConnection(accessToken))
+	})
+	t.Run("every root field has to be allowed", func(t *testing.T) {
+		operation := &graphql.Operation{Type: "mutation", RootFields: []string{"resetPassword", "updateAnime"}}
+
+		assert.ErrorIs(t, policy.Authorize(operation, resetToken), access.ErrForbidden)
+	})
+	t.Run("matches operation types and names", func(t *testing.T) {
+		policy := newPolicy(t, access.DefaultAllow,
+			config.AccessRule{Types: []string{"mutation"}, Operations: []string{"Update"}, Roles: []string{"admin"}},
+		)
+
+		assert.ErrorIs(t, policy.Authorize(updateAnime, accessToken), access.ErrForbidden)
+		assert.NoError(t, policy.Authorize(&graphql.Operation{Type: "query", Name: "Update", RootFields: []string{"anime"}}, nil))
+	})
+	t.Run("requires every scope and any role", func(t *testing.T) {
+		policy := newPolicy(t, access.DefaultDeny,
+			config.AccessRule{Fields: []string{"updateAnime"}, Scopes: []string{"anime:read", "anime:write"}, Roles: []string{"admin", "editor"}},
+			config.AccessRule{Fields: []string{"deleteAnime"}, Scopes: []string{"anime:delete"}},
+		)
+
+		assert.NoError(t, policy.Authorize(updateAnime, accessToken))
+		err := policy.Authorize(&graphql.Operation{Type: "mutation", RootFields: []string{"deleteAnime"}}, accessToken)
+		assert.EqualError(t, err, `not authorized to access "deleteAnime": missing scope "anime:delete"`)
+	})
+	t.Run("falls back to the default for fields no rule matches", func(t *testing.T) {
+		operation := &graphql.Operation{Type: "query", RootFields: []string{"anime"}}
+
+		assert.NoError(t, newPolicy(t, access.DefaultAllow).Authorize(operation, nil))
+		assert.ErrorIs(t, newPolicy(t, access.DefaultDeny).Authorize(operation, accessToken), access.ErrForbidden)
+	})
+	t.Run("rejects an unknown default", func(t *testing.T) {
+		_, err := access.NewPolicy(&config.Config{AccessDefault: "maybe"})
+
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,32 @@
+package access
+
+import (
+	"errors"
+
+	"github.com/weeb-vip/gateway-proxy/config"
+)
+
+const (
+	DefaultAllow = "allow"
+	DefaultDeny  = "deny"
+	// wildcard matches any value, including operations the gateway couldn't parse
+	wildcard = "*"
+)
+
+var ErrForbidden = errors.New("not authorized")
+
+// Policy decides which GraphQL root fields a token may call
+type Policy struct {
+	rules []config.AccessRule
+	// targeted is set when a rule names types, operations or fields, those can't be checked on unresolved operations
+	targeted     bool
+	defaultAllow bool
+	rolesClaim   string
+	scopesClaim  string
+}
+
+// DeniedError names the root field a request was refused for
+type DeniedError struct {
+	Field  string
+	Reason string
+}
@@ -0,0 +1,100 @@
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+)
+
+// Hash returns the form API keys are stored in
+func Hash(key string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// Authenticate resolves the key to its principal, represented like a verified token so the rest
+// of the gateway doesn't need to tell both apart
+func (a *Authenticator) Authenticate(ctx context.Context, key string) (*jwt.ParsedJWT, error) {
+	record, err := a.store.Lookup(ctx, Hash(key))
+	if err != nil {
+		return nil, fmt.Errorf("API key lookup failed: %w", err)
+	}
+	if record == nil {
+		return nil, ErrInvalidKey
+	}
+
+	limit := a.defaultRateLimit
+	if record.RateLimitPerMinute != 0 {
+		limit = record.RateLimitPerMinute
+	}
+	if limit > 0 {
+		allowed, err := a.limiter.Allow(ctx, record.ID, limit)
+		if err != nil {
+			log := logger.FromCtx(ctx)
+			log.Warn().Err(err).Str("api_key_id", record.ID).Msg("API key rate limit check failed, allowing request")
+		} else if !allowed {
+			return nil, fmt.Errorf("%w for %s", ErrRateLimited, record.ID)
+		}
+	}
+
+	a.touch(ctx, record.ID)
+
+	return principal(record), nil
+}
+
+// touch records the use of a key, writing it back at most once per lastUsedInterval
+func (a *Authenticator) touch(ctx context.Context, id string) {
+	now := a.now()
+	a.mu.Lock()
+	last, ok := a.lastUsed[id]
+	if ok && now.Sub(last) < lastUsedInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.lastUsed[id] = now
+	a.mu.Unlock()
+
+	if err := a.store.TouchLastUsed(ctx, id, now); err != nil {
+		log := logger.FromCtx(ctx)
+		log.Warn().Err(err).Str("api_key_id", id).Msg("Failed to record API key use")
+	}
+}
+
+func principal(record *Record) *jwt.ParsedJWT {
+	claims := make(map[string]any, len(record.Claims)+3)
+	for name, value := range record.Claims {
+		claims[name] = value
+	}
+	subject := record.Subject
+	claims["sub"] = subject
+	claims["api_key_id"] = record.ID
+
+	parsed := &jwt.ParsedJWT{
+		Subject: &subject,
+		Claims:  claims,
+	}
+	if record.Purpose != "" {
+		purpose := record.Purpose
+		claims["purpose"] = purpose
+		parsed.Purpose = &purpose
+	}
+
+	return parsed
+}
+
+// NewAuthenticator checks keys against the store, defaultRateLimit applies per key and minute, 0 disables it
+func NewAuthenticator(store Store, limiter Limiter, defaultRateLimit int) *Authenticator {
+	return &Authenticator{
+		store:            store,
+		limiter:          limiter,
+		defaultRateLimit: defaultRateLimit,
+		now:              time.Now,
+		lastUsed:         map[string]time.Time{},
+	}
+}
@@ -0,0 +1,117 @@
+package apikey_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/apikey"
+)
+
+func writeKeyFile(t *testing.T, records ...apikey.Record) string {
+	body, err := json.Marshal(records)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, body, 0o600))
+
+	return path
+}
+
+func TestAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	importer := apikey.Record{
+		ID:      "batch-importer",
+		Hash:    apikey.Hash("secret-key"),
+		Subject: "service:batch-importer",
+		Purpose: "access",
+		Claims:  map[string]any{"roles": []any{"importer"}},
+	}
+
+	t.Run("maps a key from the file store to its principal", func(t *testing.T) {
+		store, err := apikey.NewFileStore(writeKeyFile(t, importer))
+		require.NoError(t, err)
+		authenticator := apikey.NewAuthenticator(store, apikey.NewMemoryLimiter(), 0)
+
+		principal, err := authenticator.Authenticate(ctx, "secret-key")
+
+		require.NoError(t, err)
+		assert.Equal(t, "service:batch-importer", *principal.Subject)
+		assert.Equal(t, "access", *principal.Purpose)
+		roles, ok := principal.Claim("roles")
+		assert.True(t, ok)
+		assert.Equal(t, []any{"importer"}, roles)
+		apiKeyID, _ := principal.Claim("api_key_id")
+		assert.Equal(t, "batch-importer", apiKeyID)
+	})
+	t.Run("refuses unknown keys", func(t *testing.T) {
+		store, err := apikey.NewFileStore(writeKeyFile(t, importer))
+		require.NoError(t, err)
+		authenticator := apikey.NewAuthenticator(store, apikey.NewMemoryLimiter(), 0)
+
+		_, err = authenticator.Authenticate(ctx, "other-key")
+
+		assert.ErrorIs(t, err, apikey.ErrInvalidKey)
+	})
+	t.Run("limits requests per key and minute", func(t *testing.T) {
+		limited := importer
+		limited.RateLimitPerMinute = 2
+		store, err := apikey.NewFileStore(writeKeyFile(t, limited))
+		require.NoError(t, err)
+		authenticator := apikey.NewAuthenticator(store, apikey.NewMemoryLimiter(), 100)
+
+		for i := 0; i < 2; i++ {
+			_, err := authenticator.Authenticate(ctx, "secret-key")
+			require.NoError(t, err)
+		}
+		_, err = authenticator.Authenticate(ctx, "secret-key")
+
+		assert.ErrorIs(t, err, apikey.ErrRateLimited)
+	})
+	t.Run("reads keys, counts requests and records the last use in Redis", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		defer client.Close()
+		body, err := json.Marshal(importer)
+		require.NoError(t, err)
+		require.NoError(t, server.Set("apikey:"+importer.Hash, string(body)))
+		authenticator := apikey.NewAuthenticator(apikey.NewRedisStore(client), apikey.NewRedisLimiter(client), 1)
+
+		principal, err := authenticator.Authenticate(ctx, "secret-key")
+		require.NoError(t, err)
+		assert.Equal(t, "service:batch-importer", *principal.Subject)
+		assert.NotEmpty(t, server.HGet("apikey:last_used", "batch-importer"))
+
+		_, err = authenticator.Authenticate(ctx, "secret-key")
+		assert.ErrorIs(t, err, apikey.ErrRateLimited)
+		_, err = authenticator.Authenticate(ctx, "other-key")
+		assert.ErrorIs(t, err, apikey.ErrInvalidKey)
+	})
+	t.Run("rejects key files with incomplete records", func(t *testing.T) {
+		_, err := apikey.NewFileStore(writeKeyFile(t, apikey.Record{ID: "no-hash"}))
+
+		assert.Error(t, err)
+	})
+	t.Run("matches hashes written in uppercase", func(t *testing.T) {
+		uppercase := importer
+		uppercase.Hash = strings.ToUpper(importer.Hash)
+		store, err := apikey.NewFileStore(writeKeyFile(t, uppercase))
+		require.NoError(t, err)
+
+		principal, err := apikey.NewAuthenticator(store, apikey.NewMemoryLimiter(), 0).Authenticate(ctx, "secret-key")
+
+		require.NoError(t, err)
+		assert.Equal(t, "service:batch-importer", *principal.Subject)
+	})
+	t.Run("rejects hashes that aren't hex encoded SHA-256", func(t *testing.T) {
+		_, err := apikey.NewFileStore(writeKeyFile(t, apikey.Record{ID: "plain", Hash: "secret-key"}))
+
+		assert.ErrorContains(t, err, "plain")
+	})
+}
@@ -0,0 +1,139 @@
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisKeyPrefix   = "apikey:"
+	redisLastUsedKey = "apikey:last_used"
+	redisRatePrefix  = "apikey:rate:"
+)
+
+func (f *fileStore) Lookup(_ context.Context, hash string) (*Record, error) {
+	record, ok := f.records[hash]
+	if !ok {
+		return nil, nil
+	}
+
+	return record, nil
+}
+
+func (f *fileStore) TouchLastUsed(_ context.Context, id string, usedAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastUsed[id] = usedAt
+
+	return nil
+}
+
+// NewFileStore loads the API keys of a JSON file holding a list of records
+func NewFileStore(path string) (Store, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	if err := json.Unmarshal(body, &records); err != nil {
+		return nil, fmt.Errorf("invalid API key file: %w", err)
+	}
+
+	store := &fileStore{records: make(map[string]*Record, len(records)), lastUsed: map[string]time.Time{}}
+	for _, record := range records {
+		if record.ID == "" || record.Hash == "" {
+			return nil, errors.New("invalid API key file: every key needs an id and a hash")
+		}
+		// Hash renders lowercase hex, a hash written in capitals would never match
+		record.Hash = strings.ToLower(record.Hash)
+		if decoded, err := hex.DecodeString(record.Hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid API key file: the hash of %s isn't a hex encoded SHA-256", record.ID)
+		}
+		store.records[record.Hash] = record
+	}
+
+	return store, nil
+}
+
+type redisStore struct {
+	client *redis.Client
+}
+
+// Lookup reads the record stored as JSON under apikey:<hash>
+func (r redisStore) Lookup(ctx context.Context, hash string) (*Record, error) {
+	body, err := r.client.Get(ctx, redisKeyPrefix+hash).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record Record
+	if err := json.Unmarshal(body, &record); err != nil {
+		return nil, fmt.Errorf("malformed API key record: %w", err)
+	}
+	record.Hash = hash
+
+	return &record, nil
+}
+
+func (r redisStore) TouchLastUsed(ctx context.Context, id string, usedAt time.Time) error {
+	return r.client.HSet(ctx, redisLastUsedKey, id, usedAt.UTC().Format(time.RFC3339)).Err()
+}
+
+func NewRedisStore(client *redis.Client) Store {
+	return redisStore{client: client}
+}
+
+type redisLimiter struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+// Allow counts the request in the current window, the counter is shared by every replica
+func (r redisLimiter) Allow(ctx context.Context, id string, limit int) (bool, error) {
+	window := r.now().Unix() / 60
+	key := fmt.Sprintf("%s%s:%d", redisRatePrefix, id, window)
+
+	pipe := r.client.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, 2*time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+
+	return count.Val() <= int64(limit), nil
+}
+
+func NewRedisLimiter(client *redis.Client) Limiter {
+	return redisLimiter{client: client, now: time.Now}
+}
+
+// Allow counts the request in the current window, the counter is local to this replica
+func (m *memoryLimiter) Allow(_ context.Context, id string, limit int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	window := m.now().Unix() / 60
+	if window != m.window {
+		m.window = window
+		m.counter = map[string]int{}
+	}
+	m.counter[id]++
+
+	return m.counter[id] <= limit, nil
+}
+
+func NewMemoryLimiter() Limiter {
+	return &memoryLimiter{counter: map[string]int{}, now: time.Now}
+}
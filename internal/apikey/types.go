@@ -0,0 +1,67 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	StoreFile  = "file"
+	StoreRedis = "redis"
+
+	// lastUsedInterval throttles how often the last use of a key is written back to the store
+	lastUsedInterval = time.Minute
+)
+
+var (
+	ErrInvalidKey  = errors.New("invalid API key")
+	ErrRateLimited = errors.New("API key rate limit exceeded")
+)
+
+// Record is a stored API key, only the SHA-256 hash of the key itself is kept
+type Record struct {
+	ID      string         `json:"id" yaml:"id"`
+	Hash    string         `json:"hash" yaml:"hash"` // hex encoded SHA-256 of the key
+	Subject string         `json:"subject" yaml:"subject"`
+	Purpose string         `json:"purpose" yaml:"purpose"`
+	Claims  map[string]any `json:"claims" yaml:"claims"`
+	// RateLimitPerMinute overrides the default limit, 0 keeps the default and -1 disables limiting
+	RateLimitPerMinute int `json:"rate_limit_per_minute" yaml:"rate_limit_per_minute"`
+}
+
+// Store looks up API keys by hash
+type Store interface {
+	Lookup(ctx context.Context, hash string) (*Record, error)
+	TouchLastUsed(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// Limiter counts requests per key in fixed one minute windows
+type Limiter interface {
+	Allow(ctx context.Context, id string, limit int) (bool, error)
+}
+
+// Authenticator turns API keys into principals
+type Authenticator struct {
+	store            Store
+	limiter          Limiter
+	defaultRateLimit int
+	now              func() time.Time
+
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+}
+
+type fileStore struct {
+	records  map[string]*Record
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+}
+
+type memoryLimiter struct {
+	mu      sync.Mutex
+	window  int64
+	counter map[string]int
+	now     func() time.Time
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+)
+
+type ctxKey struct{}
+
+// WithResult returns a copy of ctx carrying the authentication result
+func WithResult(ctx context.Context, result *Result) context.Context {
+	return context.WithValue(ctx, ctxKey{}, result)
+}
+
+// FromContext returns the authentication result stored by the auth middleware, if any
+func FromContext(ctx context.Context) (*Result, bool) {
+	result, ok := ctx.Value(ctxKey{}).(*Result)
+	return result, ok
+}
+
+// Authenticate extracts the token from the request and verifies it
+func Authenticate(request *http.Request, parser jwt.Parser, extractors Extractors) *Result {
+	token := extractors.Extract(request)
+	if token == "" {
+		return &Result{}
+	}
+
+	claims, err := parser.Parse(token)
+	if err != nil {
+		return &Result{Method: MethodJWT, Token: token, Err: err}
+	}
+
+	return &Result{Method: MethodJWT, Token: token, Claims: claims}
+}
+
+// ValidateEnforcement refuses unknown enforcement modes, a typo must not turn enforcement off
+func ValidateEnforcement(mode string) error {
+	switch mode {
+	case EnforcementOff, EnforcementRejectInvalid, EnforcementRequireValid:
+		return nil
+	default:
+		return fmt.Errorf("unknown auth enforcement %q, expected %q, %q or %q", mode, EnforcementOff, EnforcementRejectInvalid, EnforcementRequireValid)
+	}
+}
+
+// Enforce decides whether the request may proceed under the given enforcement mode.
+// Refusals only carry the short reason of the token error, the details stay in the logs
+func Enforce(mode string, result *Result) error {
+	switch mode {
+	case EnforcementRejectInvalid:
+		if result.Err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidToken, jwt.Reason(result.Err))
+		}
+	case EnforcementRequireValid:
+		if result.Anonymous() {
+			return ErrMissingToken
+		}
+		if result.Err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidToken, jwt.Reason(result.Err))
+		}
+	}
+
+	return nil
+}
+
+type graphQLError struct {
+	Message    string            `json:"message"`
+	Extensions map[string]string `json:"extensions"`
+}
+
+type graphQLErrorResponse struct {
+	Errors []graphQLError `json:"errors"`
+}
+
+// WriteUnauthorized writes a 401 response with a GraphQL-style error body
+func WriteUnauthorized(w http.ResponseWriter, err error) {
+	challenge := `Bearer realm="gateway"`
+	if !errors.Is(err, ErrMissingToken) {
+		challenge = fmt.Sprintf(`Bearer realm="gateway", error="invalid_token", error_description=%q`, err.Error())
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+
+	_ = json.NewEncoder(w).Encode(graphQLErrorResponse{
+		Errors: []graphQLError{{
+			Message:    err.Error(),
+			Extensions: map[string]string{"code": "UNAUTHENTICATED"},
+		}},
+	})
+}
+
+// WriteForbidden writes a 403 response with a GraphQL-style error body
+func WriteForbidden(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+
+	_ = json.NewEncoder(w).Encode(graphQLErrorResponse{
+		Errors: []graphQLError{{
+			Message:    err.Error(),
+			Extensions: map[string]string{"code": "FORBIDDEN"},
+		}},
+	})
+}
+
+// WriteRateLimited writes a 429 response with a GraphQL-style error body, limits are counted per minute
+func WriteRateLimited(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", strconv.FormatInt(60-time.Now().Unix()%60, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	_ = json.NewEncoder(w).Encode(graphQLErrorResponse{
+		Errors: []graphQLError{{
+			Message:    err.Error(),
+			Extensions: map[string]string{"code": "RATE_LIMITED"},
+		}},
+	})
+}
@@ -0,0 +1,164 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwtlib "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+)
+
+type mockParser struct {
+	token *jwt.ParsedJWT
+	err   error
+}
+
+func (m mockParser) Parse(token string) (*jwt.ParsedJWT, error) {
+	return m.token, m.err
+}
+
+func TestDefaultExtractors(t *testing.T) {
+	t.Run("header mode only reads the Authorization header", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "cookie-token"})
+
+		assert.Equal(t, "", auth.DefaultExtractors("header").Extract(request))
+
+		request.Header.Set("Authorization", "Bearer header-token")
+		assert.Equal(t, "header-token", auth.DefaultExtractors("header").Extract(request))
+	})
+	t.Run("cookie mode only reads the access_token cookie", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer header-token")
+
+		assert.Equal(t, "", auth.DefaultExtractors("cookie").Extract(request))
+
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "cookie-token"})
+		assert.Equal(t, "cookie-token", auth.DefaultExtractors("cookie").Extract(request))
+	})
+	t.Run("both mode prefers the header and falls back to the cookie", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "cookie-token"})
+
+		assert.Equal(t, "cookie-token", auth.DefaultExtractors("both").Extract(request))
+
+		request.Header.Set("Authorization", "Bearer header-token")
+		assert.Equal(t, "header-token", auth.DefaultExtractors("both").Extract(request))
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Run("request without token is anonymous and never parsed", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+
+		result := auth.Authenticate(request, mockParser{err: errors.New("must not be called")}, auth.DefaultExtractors("both"))
+
+		assert.True(t, result.Anonymous())
+		assert.NoError(t, result.Err)
+		assert.Nil(t, result.Claims)
+	})
+	t.Run("invalid token keeps the parser error", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer bad")
+
+		result := auth.Authenticate(request, mockParser{err: errors.New("token is expired")}, auth.DefaultExtractors("both"))
+
+		assert.False(t, result.Anonymous())
+		assert.EqualError(t, result.Err, "token is expired")
+		assert.Nil(t, result.Claims)
+	})
+}
+
+func TestEnforce(t *testing.T) {
+	anonymous := &auth.Result{}
+	invalid := &auth.Result{Method: auth.MethodJWT, Token: "bad", Err: errors.New("token is expired")}
+	valid := &auth.Result{Method: auth.MethodJWT, Token: "good", Claims: &jwt.ParsedJWT{}}
+
+	t.Run("off lets everything through", func(t *testing.T) {
+		assert.NoError(t, auth.Enforce(auth.EnforcementOff, anonymous))
+		assert.NoError(t, auth.Enforce(auth.EnforcementOff, invalid))
+		assert.NoError(t, auth.Enforce(auth.EnforcementOff, valid))
+	})
+	t.Run("reject-invalid only refuses tokens that don't verify", func(t *testing.T) {
+		assert.NoError(t, auth.Enforce(auth.EnforcementRejectInvalid, anonymous))
+		assert.ErrorIs(t, auth.Enforce(auth.EnforcementRejectInvalid, invalid), auth.ErrInvalidToken)
+		assert.NoError(t, auth.Enforce(auth.EnforcementRejectInvalid, valid))
+	})
+	t.Run("require-valid refuses anonymous and invalid requests", func(t *testing.T) {
+		assert.ErrorIs(t, auth.Enforce(auth.EnforcementRequireValid, anonymous), auth.ErrMissingToken)
+		assert.ErrorIs(t, auth.Enforce(auth.EnforcementRequireValid, invalid), auth.ErrInvalidToken)
+		assert.NoError(t, auth.Enforce(auth.EnforcementRequireValid, valid))
+	})
+}
+
+func TestValidateEnforcement(t *testing.T) {
+	t.Run("accepts the known modes", func(t *testing.T) {
+		for _, mode := range []string{auth.EnforcementOff, auth.EnforcementRejectInvalid, auth.EnforcementRequireValid} {
+			assert.NoError(t, auth.ValidateEnforcement(mode))
+		}
+	})
+	t.Run("refuses unknown modes", func(t *testing.T) {
+		assert.Error(t, auth.ValidateEnforcement("require_valid"))
+		assert.Error(t, auth.ValidateEnforcement(""))
+	})
+}
+
+func TestWriteUnauthorized(t *testing.T) {
+	t.Run("invalid token gets an invalid_token challenge and a GraphQL error body", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+
+		expired := fmt.Errorf("%w by 1m, key kid-1 of https://auth.weeb.vip", jwtlib.ErrTokenExpired)
+		auth.WriteUnauthorized(recorder, auth.Enforce(auth.EnforcementRejectInvalid, &auth.Result{Method: auth.MethodJWT, Token: "bad", Err: expired}))
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+		var body struct {
+			Errors []struct {
+				Message    string            `json:"message"`
+				Extensions map[string]string `json:"extensions"`
+			} `json:"errors"`
+		}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		assert.Len(t, body.Errors, 1)
+		assert.Equal(t, "invalid token: expired", body.Errors[0].Message)
+		assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `error_description="invalid token: expired"`)
+		assert.Equal(t, "UNAUTHENTICATED", body.Errors[0].Extensions["code"])
+	})
+	t.Run("missing token gets a bare challenge", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+
+		auth.WriteUnauthorized(recorder, auth.ErrMissingToken)
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, `Bearer realm="gateway"`, recorder.Header().Get("WWW-Authenticate"))
+	})
+}
+
+func TestWriteForbidden(t *testing.T) {
+	t.Run("refused operations get a FORBIDDEN GraphQL error", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+
+		auth.WriteForbidden(recorder, errors.New(`not authorized to access "updateAnime"`))
+
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("WWW-Authenticate"))
+		var body struct {
+			Errors []struct {
+				Message    string            `json:"message"`
+				Extensions map[string]string `json:"extensions"`
+			} `json:"errors"`
+		}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		assert.Len(t, body.Errors, 1)
+		assert.Equal(t, `not authorized to access "updateAnime"`, body.Errors[0].Message)
+		assert.Equal(t, "FORBIDDEN", body.Errors[0].Extensions["code"])
+	})
+}
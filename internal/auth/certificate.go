@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+)
+
+const (
+	PrincipalCommonName = "cn"
+	PrincipalDNS        = "dns"
+	PrincipalURI        = "uri"
+	PrincipalEmail      = "email"
+
+	// CertificateSubjectPrefix keeps certificate principals out of the namespace of user ids in the sub claim
+	CertificateSubjectPrefix = "mtls:"
+)
+
+// CertificateAuthenticator maps the verified client certificate of a connection to a principal
+type CertificateAuthenticator struct {
+	source    string
+	principal func(certificate *x509.Certificate) string
+}
+
+// Authenticate returns an anonymous result for requests without a verified client certificate
+func (c *CertificateAuthenticator) Authenticate(request *http.Request) *Result {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return &Result{}
+	}
+	certificate := request.TLS.VerifiedChains[0][0]
+
+	principal := c.principal(certificate)
+	if principal == "" {
+		return &Result{Method: MethodMTLS, Err: fmt.Errorf("%w: client certificate has no %s", ErrInvalidToken, c.source)}
+	}
+
+	return &Result{Method: MethodMTLS, Claims: certificateClaims(certificate, principal), Principal: principal}
+}
+
+// NewCertificateAuthenticator takes the principal from the common name or the first SAN of the given type
+func NewCertificateAuthenticator(source string) (*CertificateAuthenticator, error) {
+	var principal func(certificate *x509.Certificate) string
+	switch source {
+	case PrincipalCommonName:
+		principal = func(certificate *x509.Certificate) string {
+			return certificate.Subject.CommonName
+		}
+	case PrincipalDNS:
+		principal = func(certificate *x509.Certificate) string {
+			return first(certificate.DNSNames)
+		}
+	case PrincipalURI:
+		principal = func(certificate *x509.Certificate) string {
+			if len(certificate.URIs) == 0 {
+				return ""
+			}
+			return certificate.URIs[0].String()
+		}
+	case PrincipalEmail:
+		principal = func(certificate *x509.Certificate) string {
+			return first(certificate.EmailAddresses)
+		}
+	default:
+		return nil, fmt.Errorf("unknown certificate principal source %q", source)
+	}
+
+	return &CertificateAuthenticator{source: source, principal: principal}, nil
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// certificateClaims exposes the certificate like token claims, so claim headers and access rules apply to it.
+// The subject is the prefixed principal, a certificate named like a user must not pass for that user
+func certificateClaims(certificate *x509.Certificate, principal string) *jwt.ParsedJWT {
+	subject := CertificateSubjectPrefix + principal
+	fingerprint := sha256.Sum256(certificate.Raw)
+	uris := make([]any, len(certificate.URIs))
+	for i, uri := range certificate.URIs {
+		uris[i] = uri.String()
+	}
+	dnsNames := make([]any, len(certificate.DNSNames))
+	for i, name := range certificate.DNSNames {
+		dnsNames[i] = name
+	}
+
+	return &jwt.ParsedJWT{
+		Subject:   &subject,
+		Issuer:    certificate.Issuer.String(),
+		ExpiresAt: certificate.NotAfter,
+		Claims: map[string]any{
+			"sub":                     subject,
+			"service_principal":       principal,
+			"cert_subject":            certificate.Subject.String(),
+			"cert_issuer":             certificate.Issuer.String(),
+			"cert_serial":             certificate.SerialNumber.String(),
+			"cert_fingerprint_sha256": hex.EncodeToString(fingerprint[:]),
+			"cert_dns_names":          dnsNames,
+			"cert_uris":               uris,
+		},
+	}
+}
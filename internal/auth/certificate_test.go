@@ -0,0 +1,99 @@
+package auth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
+)
+
+func TestCertificateAuthenticator(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://weeb.vip/batch-importer")
+	certificate := &x509.Certificate{
+		Raw:            []byte("certificate"),
+		SerialNumber:   big.NewInt(42),
+		Subject:        pkix.Name{CommonName: "batch-importer"},
+		Issuer:         pkix.Name{CommonName: "internal-ca"},
+		NotAfter:       time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:       []string{"importer.internal"},
+		URIs:           []*url.URL{spiffe},
+		EmailAddresses: []string{"importer@weeb.vip"},
+	}
+	withCertificate := func(certificate *x509.Certificate) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+	}
+
+	t.Run("maps the configured certificate field to the principal", func(t *testing.T) {
+		expected := map[string]string{
+			auth.PrincipalCommonName: "batch-importer",
+			auth.PrincipalDNS:        "importer.internal",
+			auth.PrincipalURI:        "spiffe://weeb.vip/batch-importer",
+			auth.PrincipalEmail:      "importer@weeb.vip",
+		}
+		for source, subject := range expected {
+			authenticator, err := auth.NewCertificateAuthenticator(source)
+			require.NoError(t, err)
+			request := httptest.NewRequest("GET", "/", nil)
+			request.TLS = withCertificate(certificate)
+
+			result := authenticator.Authenticate(request)
+
+			require.NoError(t, result.Err)
+			assert.Equal(t, auth.MethodMTLS, result.Method)
+			assert.Equal(t, subject, result.Principal)
+			assert.Equal(t, "mtls:"+subject, *result.Claims.Subject)
+			assert.Equal(t, "mtls:"+subject, result.Claims.Claims["sub"])
+			assert.Equal(t, subject, result.Claims.Claims["service_principal"])
+		}
+	})
+	t.Run("exposes the certificate details as claims", func(t *testing.T) {
+		authenticator, err := auth.NewCertificateAuthenticator(auth.PrincipalCommonName)
+		require.NoError(t, err)
+		request := httptest.NewRequest("GET", "/", nil)
+		request.TLS = withCertificate(certificate)
+
+		result := authenticator.Authenticate(request)
+
+		require.NoError(t, result.Err)
+		assert.Equal(t, "CN=internal-ca", result.Claims.Issuer)
+		assert.Equal(t, certificate.NotAfter, result.Claims.ExpiresAt)
+		assert.Equal(t, "42", result.Claims.Claims["cert_serial"])
+		assert.Equal(t, "CN=batch-importer", result.Claims.Claims["cert_subject"])
+		assert.Equal(t, []any{"importer.internal"}, result.Claims.Claims["cert_dns_names"])
+		assert.Len(t, result.Claims.Claims["cert_fingerprint_sha256"], 64)
+	})
+	t.Run("requests without a verified certificate are anonymous", func(t *testing.T) {
+		authenticator, err := auth.NewCertificateAuthenticator(auth.PrincipalCommonName)
+		require.NoError(t, err)
+		plain := httptest.NewRequest("GET", "/", nil)
+		unverified := httptest.NewRequest("GET", "/", nil)
+		unverified.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+
+		assert.True(t, authenticator.Authenticate(plain).Anonymous())
+		assert.True(t, authenticator.Authenticate(unverified).Anonymous())
+	})
+	t.Run("certificate without the configured field is invalid", func(t *testing.T) {
+		authenticator, err := auth.NewCertificateAuthenticator(auth.PrincipalURI)
+		require.NoError(t, err)
+		request := httptest.NewRequest("GET", "/", nil)
+		request.TLS = withCertificate(&x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "no-san"}})
+
+		result := authenticator.Authenticate(request)
+
+		assert.ErrorIs(t, result.Err, auth.ErrInvalidToken)
+		assert.Nil(t, result.Claims)
+	})
+	t.Run("unknown principal source is refused", func(t *testing.T) {
+		_, err := auth.NewCertificateAuthenticator("serial")
+
+		assert.Error(t, err)
+	})
+}
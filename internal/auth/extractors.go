@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/weeb-vip/gateway-proxy/config"
+)
+
+const (
+	SourceHeader            = "header"
+	SourceCookie            = "cookie"
+	SourceQuery             = "query"
+	SourceWebSocketProtocol = "websocket-protocol"
+
+	defaultTokenName       = "access_token"
+	defaultProtocolPrefix  = "access_token."
+	webSocketProtocolField = "Sec-WebSocket-Protocol"
+)
+
+// Extractor reads a token from one place of the request, it returns an empty string when there is none
+type Extractor interface {
+	Extract(request *http.Request) string
+	// Strip removes the token from the request so it isn't forwarded upstream
+	Strip(request *http.Request)
+}
+
+// Extractors are tried in order, the first token found wins
+type Extractors []Extractor
+
+func (e Extractors) Extract(request *http.Request) string {
+	for _, extractor := range e {
+		if token := extractor.Extract(request); token != "" {
+			return token
+		}
+	}
+
+	return ""
+}
+
+// CookieName is the name of the first cookie tokens are read from, empty when no cookie is a source
+func (e Extractors) CookieName() string {
+	for _, extractor := range e {
+		if cookie, ok := extractor.(cookieExtractor); ok {
+			return cookie.name
+		}
+	}
+
+	return ""
+}
+
+// Strip removes the token from every source, for requests that must not carry the user's token upstream
+func (e Extractors) Strip(request *http.Request) {
+	for _, extractor := range e {
+		extractor.Strip(request)
+	}
+}
+
+// StripWebSocket removes tokens read from the query string or the WebSocket protocol list. Only browsers' WebSocket
+// upgrades put them there, upstreams would log the URL and can't negotiate a token as a protocol
+func (e Extractors) StripWebSocket(request *http.Request) {
+	for _, extractor := range e {
+		switch extractor.(type) {
+		case queryExtractor, protocolExtractor:
+			extractor.Strip(request)
+		}
+	}
+}
+
+type headerExtractor struct {
+	name   string
+	scheme string
+}
+
+func (h headerExtractor) Extract(request *http.Request) string {
+	value := request.Header.Get(h.name)
+	if h.scheme == "" {
+		return strings.TrimSpace(value)
+	}
+	scheme, token, ok := strings.Cut(value, " ")
+	if !ok || !strings.EqualFold(scheme, h.scheme) {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+func (h headerExtractor) Strip(request *http.Request) {
+	// a header with another scheme isn't ours to remove
+	if h.Extract(request) != "" {
+		request.Header.Del(h.name)
+	}
+}
+
+type cookieExtractor struct {
+	name string
+}
+
+func (c cookieExtractor) Extract(request *http.Request) string {
+	cookie, err := request.Cookie(c.name)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+func (c cookieExtractor) Strip(request *http.Request) {
+	cookies := request.Cookies()
+	request.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != c.name {
+			request.AddCookie(cookie)
+		}
+	}
+}
+
+// queryExtractor only applies to WebSocket upgrades, browsers can't set headers on those
+// and a token in the URL of any other request would end up in access logs for nothing
+type queryExtractor struct {
+	name string
+}
+
+func (q queryExtractor) Extract(request *http.Request) string {
+	if !isWebSocketUpgrade(request) {
+		return ""
+	}
+
+	return request.URL.Query().Get(q.name)
+}
+
+func (q queryExtractor) Strip(request *http.Request) {
+	query := request.URL.Query()
+	if !query.Has(q.name) {
+		return
+	}
+	query.Del(q.name)
+	request.URL.RawQuery = query.Encode()
+}
+
+// protocolExtractor reads a token smuggled as a Sec-WebSocket-Protocol entry, e.g. "access_token.<jwt>"
+type protocolExtractor struct {
+	prefix string
+}
+
+func (p protocolExtractor) Extract(request *http.Request) string {
+	for _, header := range request.Header.Values(webSocketProtocolField) {
+		for _, protocol := range strings.Split(header, ",") {
+			if token, ok := strings.CutPrefix(strings.TrimSpace(protocol), p.prefix); ok && token != "" {
+				return token
+			}
+		}
+	}
+
+	return ""
+}
+
+func (p protocolExtractor) Strip(request *http.Request) {
+	values := request.Header.Values(webSocketProtocolField)
+	if len(values) == 0 {
+		return
+	}
+
+	var protocols []string
+	for _, header := range values {
+		for _, protocol := range strings.Split(header, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if protocol != "" && !strings.HasPrefix(protocol, p.prefix) {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	request.Header.Del(webSocketProtocolField)
+	if len(protocols) > 0 {
+		request.Header.Set(webSocketProtocolField, strings.Join(protocols, ", "))
+	}
+}
+
+func isWebSocketUpgrade(request *http.Request) bool {
+	return strings.EqualFold(request.Header.Get("Upgrade"), "websocket")
+}
+
+// DefaultExtractors mirrors the auth mode: "header", "cookie", or the header with the cookie as fallback
+func DefaultExtractors(authMode string) Extractors {
+	header := headerExtractor{name: "Authorization", scheme: "Bearer"}
+	cookie := cookieExtractor{name: defaultTokenName}
+
+	switch authMode {
+	case "cookie":
+		return Extractors{cookie}
+	case "header":
+		return Extractors{header}
+	default: // "both" or any other value defaults to both
+		return Extractors{header, cookie}
+	}
+}
+
+// NewExtractors builds the configured token sources, falling back to the auth mode when none are configured
+func NewExtractors(sources []config.TokenSource, authMode string) (Extractors, error) {
+	if len(sources) == 0 {
+		return DefaultExtractors(authMode), nil
+	}
+
+	extractors := make(Extractors, 0, len(sources))
+	for _, source := range sources {
+		switch source.Type {
+		case SourceHeader:
+			extractors = append(extractors, headerExtractor{name: valueOr(source.Name, "Authorization"), scheme: source.Scheme})
+		case SourceCookie:
+			extractors = append(extractors, cookieExtractor{name: valueOr(source.Name, defaultTokenName)})
+		case SourceQuery:
+			extractors = append(extractors, queryExtractor{name: valueOr(source.Name, defaultTokenName)})
+		case SourceWebSocketProtocol:
+			extractors = append(extractors, protocolExtractor{prefix: valueOr(source.Prefix, defaultProtocolPrefix)})
+		default:
+			return nil, fmt.Errorf("unknown token source type %q", source.Type)
+		}
+	}
+
+	return extractors, nil
+}
+
+func valueOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
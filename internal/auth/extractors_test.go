@@ -0,0 +1,130 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
+)
+
+func TestNewExtractors(t *testing.T) {
+	newExtractors := func(t *testing.T, sources ...config.TokenSource) auth.Extractors {
+		extractors, err := auth.NewExtractors(sources, "both")
+		require.NoError(t, err)
+		return extractors
+	}
+
+	t.Run("falls back to the auth mode without configured sources", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer header-token")
+
+		assert.Equal(t, "header-token", newExtractors(t).Extract(request))
+	})
+	t.Run("reads a named header with a custom scheme", func(t *testing.T) {
+		extractors := newExtractors(t, config.TokenSource{Type: "header", Name: "X-Auth", Scheme: "Token"})
+		request := httptest.NewRequest("GET", "/", nil)
+
+		request.Header.Set("X-Auth", "Bearer header-token")
+		assert.Equal(t, "", extractors.Extract(request))
+
+		request.Header.Set("X-Auth", "token header-token")
+		assert.Equal(t, "header-token", extractors.Extract(request))
+	})
+	t.Run("reads the whole header value without a scheme", func(t *testing.T) {
+		extractors := newExtractors(t, config.TokenSource{Type: "header", Name: "X-Auth"})
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("X-Auth", "header-token")
+
+		assert.Equal(t, "header-token", extractors.Extract(request))
+	})
+	t.Run("reads a named cookie", func(t *testing.T) {
+		extractors := newExtractors(t, config.TokenSource{Type: "cookie", Name: "session"})
+		request := httptest.NewRequest("GET", "/", nil)
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "other"})
+		request.AddCookie(&http.Cookie{Name: "session", Value: "cookie-token"})
+
+		assert.Equal(t, "cookie-token", extractors.Extract(request))
+	})
+	t.Run("reads the query parameter of WebSocket upgrades only", func(t *testing.T) {
+		extractors := newExtractors(t, config.TokenSource{Type: "query", Name: "token"})
+		request := httptest.NewRequest("GET", "/graphql?token=query-token", nil)
+
+		assert.Equal(t, "", extractors.Extract(request))
+
+		request.Header.Set("Upgrade", "websocket")
+		assert.Equal(t, "query-token", extractors.Extract(request))
+	})
+	t.Run("reads a prefixed WebSocket subprotocol", func(t *testing.T) {
+		extractors := newExtractors(t, config.TokenSource{Type: "websocket-protocol"})
+		request := httptest.NewRequest("GET", "/graphql", nil)
+		request.Header.Set("Sec-WebSocket-Protocol", "graphql-transport-ws, access_token.protocol-token")
+
+		assert.Equal(t, "protocol-token", extractors.Extract(request))
+	})
+	t.Run("tries the sources in order", func(t *testing.T) {
+		extractors := newExtractors(t,
+			config.TokenSource{Type: "cookie"},
+			config.TokenSource{Type: "header", Scheme: "Bearer"},
+		)
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer header-token")
+
+		assert.Equal(t, "header-token", extractors.Extract(request))
+
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "cookie-token"})
+		assert.Equal(t, "cookie-token", extractors.Extract(request))
+	})
+	t.Run("rejects unknown source types", func(t *testing.T) {
+		_, err := auth.NewExtractors([]config.TokenSource{{Type: "body"}}, "both")
+
+		assert.Error(t, err)
+	})
+}
+
+func TestExtractorsStrip(t *testing.T) {
+	t.Run("keeps a header carrying another scheme", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+
+		auth.DefaultExtractors("both").Strip(request)
+
+		assert.Equal(t, "Basic dXNlcjpwYXNz", request.Header.Get("Authorization"))
+	})
+	t.Run("drops the protocol header when it only carried the token", func(t *testing.T) {
+		extractors, err := auth.NewExtractors([]config.TokenSource{{Type: "websocket-protocol"}}, "both")
+		require.NoError(t, err)
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Sec-WebSocket-Protocol", "access_token.123")
+
+		extractors.Strip(request)
+
+		assert.Empty(t, request.Header.Values("Sec-WebSocket-Protocol"))
+	})
+}
+
+func TestExtractorsStripWebSocket(t *testing.T) {
+	t.Run("removes query and protocol tokens but keeps headers and cookies", func(t *testing.T) {
+		extractors, err := auth.NewExtractors([]config.TokenSource{
+			{Type: "header"},
+			{Type: "cookie"},
+			{Type: "query"},
+			{Type: "websocket-protocol"},
+		}, "")
+		require.NoError(t, err)
+		request := httptest.NewRequest("GET", "/graphql?access_token=123&debug=1", nil)
+		request.Header.Set("Authorization", "Bearer 123")
+		request.Header.Set("Sec-WebSocket-Protocol", "graphql-transport-ws, access_token.123")
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "123"})
+
+		extractors.StripWebSocket(request)
+
+		assert.Equal(t, "debug=1", request.URL.RawQuery)
+		assert.Equal(t, "graphql-transport-ws", request.Header.Get("Sec-WebSocket-Protocol"))
+		assert.Equal(t, "Bearer 123", request.Header.Get("Authorization"))
+		assert.Equal(t, "access_token=123", request.Header.Get("Cookie"))
+	})
+}
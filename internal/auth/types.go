@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+)
+
+const (
+	// EnforcementOff forwards every request, invalid tokens are treated as anonymous
+	EnforcementOff = "off"
+	// EnforcementRejectInvalid rejects requests carrying a token that doesn't verify, anonymous requests pass
+	EnforcementRejectInvalid = "reject-invalid"
+	// EnforcementRequireValid rejects every request that doesn't carry a valid token
+	EnforcementRequireValid = "require-valid"
+)
+
+const (
+	MethodJWT    = "jwt"
+	MethodAPIKey = "api_key"
+	MethodMTLS   = "mtls"
+)
+
+var (
+	ErrMissingToken = errors.New("authentication required")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Result is the outcome of authenticating a single request
+type Result struct {
+	// Method is how the request authenticated, MethodJWT, MethodAPIKey or MethodMTLS, empty for anonymous requests
+	Method string
+	// Token is the raw JWT found on the request, empty for anonymous and API key requests
+	Token string
+	// Claims is set only when the token was verified successfully
+	Claims *jwt.ParsedJWT
+	// Principal is the certificate principal of MethodMTLS requests, it is kept apart from user ids
+	Principal string
+	// Err is the reason the token was refused, nil for anonymous or valid requests
+	Err error
+}
+
+// Anonymous reports whether the request carried no credentials at all
+func (r *Result) Anonymous() bool {
+	return r.Method == ""
+}
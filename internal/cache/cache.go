@@ -24,7 +24,8 @@ type GraphQLCache struct {
 	ctx    context.Context
 }
 
-func NewGraphQLCache(cfg *config.Config, ttl time.Duration) (*GraphQLCache, error) {
+// NewRedisClient connects to the configured Redis, other features share the connection through Client
+func NewRedisClient(cfg *config.Config) (*redis.Client, error) {
 	log := logger.Get()
 
 	opt, err := redis.ParseURL(cfg.RedisURL)
@@ -51,13 +52,27 @@ func NewGraphQLCache(cfg *config.Config, ttl time.Duration) (*GraphQLCache, erro
 		Int("redis_db", cfg.RedisDB).
 		Msg("Connected to Redis")
 
+	return client, nil
+}
+
+func NewGraphQLCache(cfg *config.Config, ttl time.Duration) (*GraphQLCache, error) {
+	client, err := NewRedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	return &GraphQLCache{
 		client: client,
 		ttl:    ttl,
-		ctx:    ctx,
+		ctx:    context.Background(),
 	}, nil
 }
 
+// Client exposes the Redis connection of the cache
+func (c *GraphQLCache) Client() *redis.Client {
+	return c.client
+}
+
 func (c *GraphQLCache) GenerateKey(userToken, requestBody string) string {
 	hash := sha256.Sum256([]byte(userToken + "|" + requestBody))
 	return fmt.Sprintf("gql_cache:%x", hash)
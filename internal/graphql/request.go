@@ -0,0 +1,211 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// ParseRequests reads the GraphQL requests carried by an HTTP request, body is the already read request body.
+// POST bodies may be a single JSON request, a JSON batch or an application/graphql document,
+// GET requests carry the request in the query string.
+func ParseRequests(r *http.Request, body []byte) ([]Request, error) {
+	if r.Method == http.MethodGet {
+		return fromQueryString(r.URL.Query())
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/graphql" {
+		return []Request{{Query: string(body), OperationName: r.URL.Query().Get("operationName")}}, nil
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, ErrNoDocument
+	}
+	if trimmed[0] == '[' {
+		var batch []Request
+		if err := unmarshal(trimmed, &batch); err != nil {
+			return nil, err
+		}
+		return batch, nil
+	}
+
+	var request Request
+	if err := unmarshal(trimmed, &request); err != nil {
+		return nil, err
+	}
+
+	return []Request{request}, nil
+}
+
+// unmarshal is json.Unmarshal keeping numbers as json.Number, variables above 2^53 lose
+// precision as float64 and distinct values would normalize the same
+func unmarshal(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("invalid character after top-level value")
+	}
+
+	return nil
+}
+
+func fromQueryString(values url.Values) ([]Request, error) {
+	request := Request{
+		Query:         values.Get("query"),
+		OperationName: values.Get("operationName"),
+	}
+	if variables := values.Get("variables"); variables != "" {
+		if err := unmarshal([]byte(variables), &request.Variables); err != nil {
+			return nil, err
+		}
+	}
+	if extensions := values.Get("extensions"); extensions != "" {
+		if err := unmarshal([]byte(extensions), &request.Extensions); err != nil {
+			return nil, err
+		}
+	}
+
+	return []Request{request}, nil
+}
+
+// Operation parses the document and resolves the operation the request executes
+func (r Request) Operation() (*Operation, error) {
+	document, operation, err := r.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Operation{
+		Type:       string(operation.Operation),
+		Name:       operation.Name,
+		RootFields: rootFields(document, operation.SelectionSet, map[string]bool{}),
+	}, nil
+}
+
+// Normalize renders the request in a canonical form: the executed operation and the fragments it uses,
+// without whitespace or comments, followed by the variables with their keys sorted.
+// Requests that only differ in formatting, unused operations or variable order normalize the same
+func (r Request) Normalize() (string, error) {
+	document, operation, err := r.parse()
+	if err != nil {
+		return "", err
+	}
+
+	used := map[string]*ast.FragmentDefinition{}
+	usedFragments(document, operation.SelectionSet, used)
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	normalized := &ast.QueryDocument{Operations: ast.OperationList{operation}}
+	for _, name := range names {
+		normalized.Fragments = append(normalized.Fragments, used[name])
+	}
+
+	var builder strings.Builder
+	formatter.NewFormatter(&builder, formatter.WithCompacted()).FormatQueryDocument(normalized)
+
+	// encoding/json sorts map keys, nested objects included, json.Number values are written as received
+	variables := []byte("{}")
+	if len(r.Variables) > 0 {
+		if variables, err = json.Marshal(r.Variables); err != nil {
+			return "", err
+		}
+	}
+	builder.WriteString("\n")
+	builder.Write(variables)
+
+	return builder.String(), nil
+}
+
+func (r Request) parse() (*ast.QueryDocument, *ast.OperationDefinition, error) {
+	if r.Query == "" {
+		// persisted queries only carry a hash, the document is unknown to the gateway
+		return nil, nil, ErrNoDocument
+	}
+	document, err := parser.ParseQuery(&ast.Source{Input: r.Query})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	operation, err := selectOperation(document, r.OperationName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return document, operation, nil
+}
+
+func selectOperation(document *ast.QueryDocument, name string) (*ast.OperationDefinition, error) {
+	if name == "" {
+		if len(document.Operations) != 1 {
+			return nil, ErrAmbiguousOperation
+		}
+		return document.Operations[0], nil
+	}
+
+	operation := document.Operations.ForName(name)
+	if operation == nil {
+		return nil, ErrOperationNotFound
+	}
+
+	return operation, nil
+}
+
+// rootFields flattens fragment spreads and inline fragments, visited guards against fragment cycles
+func rootFields(document *ast.QueryDocument, selections ast.SelectionSet, visited map[string]bool) []string {
+	var fields []string
+	for _, selection := range selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			fields = append(fields, s.Name)
+		case *ast.InlineFragment:
+			fields = append(fields, rootFields(document, s.SelectionSet, visited)...)
+		case *ast.FragmentSpread:
+			fragment := document.Fragments.ForName(s.Name)
+			if fragment == nil || visited[s.Name] {
+				continue
+			}
+			visited[s.Name] = true
+			fields = append(fields, rootFields(document, fragment.SelectionSet, visited)...)
+		}
+	}
+
+	return fields
+}
+
+// usedFragments collects the fragments spread anywhere in selections, fragments spread by fragments included
+func usedFragments(document *ast.QueryDocument, selections ast.SelectionSet, used map[string]*ast.FragmentDefinition) {
+	for _, selection := range selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			usedFragments(document, s.SelectionSet, used)
+		case *ast.InlineFragment:
+			usedFragments(document, s.SelectionSet, used)
+		case *ast.FragmentSpread:
+			fragment := document.Fragments.ForName(s.Name)
+			if fragment == nil || used[s.Name] != nil {
+				continue
+			}
+			used[s.Name] = fragment
+			usedFragments(document, fragment.SelectionSet, used)
+		}
+	}
+}
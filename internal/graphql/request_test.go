@@ -0,0 +1,206 @@
+package graphql_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/graphql"
+)
+
+func TestParseRequests(t *testing.T) {
+	t.Run("reads a single JSON request", func(t *testing.T) {
+		body := `{"query":"query Me { me { id } }","operationName":"Me","variables":{"id":1}}`
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+
+		requests, err := graphql.ParseRequests(request, []byte(body))
+
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+		assert.Equal(t, "Me", requests[0].OperationName)
+		assert.Equal(t, "query Me { me { id } }", requests[0].Query)
+	})
+	t.Run("reads a batch", func(t *testing.T) {
+		body := `[{"query":"{ me { id } }"},{"query":"mutation { logout }"}]`
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+
+		requests, err := graphql.ParseRequests(request, []byte(body))
+
+		require.NoError(t, err)
+		assert.Len(t, requests, 2)
+	})
+	t.Run("reads an application/graphql document", func(t *testing.T) {
+		body := `mutation { logout }`
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/graphql; charset=utf-8")
+
+		requests, err := graphql.ParseRequests(request, []byte(body))
+
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+		assert.Equal(t, body, requests[0].Query)
+	})
+	t.Run("reads a GET request from the query string", func(t *testing.T) {
+		query := url.Values{"query": {"{ me { id } }"}, "variables": {`{"id":1}`}}
+		request := httptest.NewRequest(http.MethodGet, "/graphql?"+query.Encode(), nil)
+
+		requests, err := graphql.ParseRequests(request, nil)
+
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+		assert.Equal(t, "{ me { id } }", requests[0].Query)
+		assert.Equal(t, map[string]any{"id": json.Number("1")}, requests[0].Variables)
+	})
+	t.Run("returns an error for an empty body", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+
+		_, err := graphql.ParseRequests(request, nil)
+
+		assert.ErrorIs(t, err, graphql.ErrNoDocument)
+	})
+}
+
+func TestRequestOperation(t *testing.T) {
+	t.Run("resolves type, name and root fields", func(t *testing.T) {
+		operation, err := graphql.Request{Query: `mutation Reset { resetPassword(token: "x") { ok } logout }`}.Operation()
+
+		require.NoError(t, err)
+		assert.Equal(t, graphql.OperationMutation, operation.Type)
+		assert.Equal(t, "Reset", operation.Name)
+		assert.Equal(t, []string{"resetPassword", "logout"}, operation.RootFields)
+	})
+	t.Run("treats the shorthand form as a query", func(t *testing.T) {
+		operation, err := graphql.Request{Query: `{ me { id } }`}.Operation()
+
+		require.NoError(t, err)
+		assert.Equal(t, graphql.OperationQuery, operation.Type)
+		assert.Equal(t, []string{"me"}, operation.RootFields)
+	})
+	t.Run("selects the named operation", func(t *testing.T) {
+		query := `query A { me { id } } mutation B { logout }`
+
+		operation, err := graphql.Request{Query: query, OperationName: "B"}.Operation()
+		require.NoError(t, err)
+		assert.Equal(t, graphql.OperationMutation, operation.Type)
+
+		_, err = graphql.Request{Query: query}.Operation()
+		assert.ErrorIs(t, err, graphql.ErrAmbiguousOperation)
+		_, err = graphql.Request{Query: query, OperationName: "C"}.Operation()
+		assert.ErrorIs(t, err, graphql.ErrOperationNotFound)
+	})
+	t.Run("collects root fields hidden in fragments", func(t *testing.T) {
+		query := `query { ...Root ... on Query { anime { id } } } fragment Root on Query { me { id } ...Root }`
+
+		operation, err := graphql.Request{Query: query}.Operation()
+
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"me", "anime"}, operation.RootFields)
+	})
+	t.Run("returns an error for persisted queries without a document", func(t *testing.T) {
+		_, err := graphql.Request{Extensions: map[string]any{"persistedQuery": map[string]any{}}}.Operation()
+
+		assert.ErrorIs(t, err, graphql.ErrNoDocument)
+	})
+	t.Run("returns an error for invalid documents", func(t *testing.T) {
+		_, err := graphql.Request{Query: `query {`}.Operation()
+
+		assert.Error(t, err)
+	})
+}
+
+func TestRequestNormalize(t *testing.T) {
+	normalize := func(t *testing.T, request graphql.Request) string {
+		normalized, err := request.Normalize()
+		require.NoError(t, err)
+
+		return normalized
+	}
+
+	t.Run("ignores whitespace and comments", func(t *testing.T) {
+		compact := graphql.Request{Query: `query Me{me{id name}}`}
+		formatted := graphql.Request{Query: "# who am I\nquery Me {\n  me {\n    id # the user id\n    name\n  }\n}\n"}
+
+		assert.Equal(t, normalize(t, compact), normalize(t, formatted))
+	})
+	t.Run("treats the shorthand form as an anonymous query", func(t *testing.T) {
+		assert.Equal(t, normalize(t, graphql.Request{Query: `{ me { id } }`}), normalize(t, graphql.Request{Query: `query { me { id } }`}))
+	})
+	t.Run("ignores the order of variables", func(t *testing.T) {
+		query := `query User($id: ID!, $filter: Filter) { user(id: $id, filter: $filter) { id } }`
+		first := graphql.Request{Query: query, Variables: map[string]any{"id": "1", "filter": map[string]any{"a": 1, "b": []any{"x", "y"}}}}
+		second := graphql.Request{Query: query, Variables: map[string]any{"filter": map[string]any{"b": []any{"x", "y"}, "a": 1}, "id": "1"}}
+
+		assert.Equal(t, normalize(t, first), normalize(t, second))
+	})
+	t.Run("treats missing and empty variables alike", func(t *testing.T) {
+		assert.Equal(t, normalize(t, graphql.Request{Query: `{ me { id } }`}), normalize(t, graphql.Request{Query: `{ me { id } }`, Variables: map[string]any{}}))
+	})
+	t.Run("keeps only the selected operation and the fragments it uses", func(t *testing.T) {
+		alone := graphql.Request{
+			Query:         `query Me { me { ...User } } fragment User on User { id ...Name } fragment Name on User { name }`,
+			OperationName: "Me",
+		}
+		withOthers := graphql.Request{
+			Query: `fragment Unused on Post { title }
+				query Posts { posts { ...Unused } }
+				fragment Name on User { name }
+				query Me { me { ...User } }
+				fragment User on User { id ...Name }`,
+			OperationName: "Me",
+		}
+
+		assert.Equal(t, normalize(t, alone), normalize(t, withOthers))
+	})
+	t.Run("tells different requests apart", func(t *testing.T) {
+		document := `query Me { me { id } } query Posts { posts { id } }`
+		requests := []graphql.Request{
+			{Query: `{ me { id } }`},
+			{Query: `{ me { id name } }`},
+			{Query: `{ me { name id } }`},
+			{Query: `{ user(id: "1") { id } }`},
+			{Query: `{ user(id: "2") { id } }`},
+			{Query: `query U($id: ID) { user(id: $id) { id } }`, Variables: map[string]any{"id": "1"}},
+			{Query: `query U($id: ID) { user(id: $id) { id } }`, Variables: map[string]any{"id": "2"}},
+			{Query: document, OperationName: "Me"},
+			{Query: document, OperationName: "Posts"},
+		}
+
+		seen := map[string]int{}
+		for i, request := range requests {
+			normalized := normalize(t, request)
+			if previous, ok := seen[normalized]; ok {
+				t.Errorf("requests %d and %d normalize the same: %s", previous, i, normalized)
+			}
+			seen[normalized] = i
+		}
+	})
+	t.Run("tells apart integer variables above 2^53", func(t *testing.T) {
+		parse := func(body string) graphql.Request {
+			requests, err := graphql.ParseRequests(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), []byte(body))
+			require.NoError(t, err)
+			return requests[0]
+		}
+		query := `"query U($id: ID) { user(id: $id) { id } }"`
+		first := parse(`{"query":` + query + `,"variables":{"id":9007199254740993}}`)
+		second := parse(`{"query":` + query + `,"variables":{"id":9007199254740992}}`)
+
+		assert.NotEqual(t, normalize(t, first), normalize(t, second))
+	})
+	t.Run("returns error for invalid documents and ambiguous operations", func(t *testing.T) {
+		_, err := graphql.Request{Query: `query {`}.Normalize()
+		assert.Error(t, err)
+
+		_, err = graphql.Request{Query: `query A { a } query B { b }`}.Normalize()
+		assert.ErrorIs(t, err, graphql.ErrAmbiguousOperation)
+
+		_, err = graphql.Request{Extensions: map[string]any{"persistedQuery": map[string]any{}}}.Normalize()
+		assert.ErrorIs(t, err, graphql.ErrNoDocument)
+	})
+}
@@ -0,0 +1,31 @@
+package graphql
+
+import "errors"
+
+const (
+	OperationQuery        = "query"
+	OperationMutation     = "mutation"
+	OperationSubscription = "subscription"
+)
+
+var (
+	ErrNoDocument         = errors.New("request has no GraphQL document")
+	ErrOperationNotFound  = errors.New("operation not found in document")
+	ErrAmbiguousOperation = errors.New("document has several operations but no operation name")
+)
+
+// Request is a single GraphQL request as sent over HTTP, a batch holds several of them
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	Extensions    map[string]any `json:"extensions,omitempty"`
+}
+
+// Operation is the operation a request executes, as far as it can be told without the schema
+type Operation struct {
+	Type string
+	Name string
+	// RootFields are the top level fields selected by the operation, fragments included
+	RootFields []string
+}
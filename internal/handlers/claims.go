@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+)
+
+// addClaimHeaders forwards the mapped claims, mapping is claim path -> header name
+func addClaimHeaders(request *http.Request, claims *jwt.ParsedJWT, mapping map[string]string) {
+	for path, header := range mapping {
+		value, ok := claims.Claim(path)
+		if !ok {
+			continue
+		}
+		encoded, ok := encodeClaim(value)
+		if !ok {
+			continue
+		}
+		request.Header.Set(header, encoded)
+	}
+}
+
+// encodeClaim renders scalars as plain text and arrays or objects as JSON
+func encodeClaim(value any) (string, bool) {
+	var encoded string
+	switch v := value.(type) {
+	case string:
+		encoded = v
+	case json.Number:
+		encoded = v.String()
+	case bool:
+		encoded = fmt.Sprint(v)
+	default:
+		marshalled, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		encoded = string(marshalled)
+	}
+
+	// a claim must never be able to smuggle extra headers
+	if strings.ContainsAny(encoded, "\r\n\x00") {
+		return "", false
+	}
+
+	return encoded, true
+}
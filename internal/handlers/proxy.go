@@ -1,86 +1,175 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
+	"github.com/weeb-vip/gateway-proxy/internal/identity"
 	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
 	"go.opentelemetry.io/otel/propagation"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"strings"
 )
 
-func GetProxy(config *config.Config, jwtParser jwt.Parser) *httputil.ReverseProxy {
-	return &httputil.ReverseProxy{Director: func(request *http.Request) {
+// IdentityMinter issues the token forwarded to subgraphs in place of the user's own token
+type IdentityMinter interface {
+	Mint(ctx context.Context, user *jwt.ParsedJWT) (string, error)
+}
+
+// errNoIdentity aborts requests whose identity token couldn't be minted, they must not reach subgraphs anonymously
+var errNoIdentity = errors.New("internal identity token couldn't be minted")
+
+type noIdentityKey struct{}
+
+// identityTransport refuses to forward requests marked by the Director as lacking their identity token
+type identityTransport struct {
+	next http.RoundTripper
+}
+
+func (t identityTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Context().Value(noIdentityKey{}) != nil {
+		return nil, errNoIdentity
+	}
+
+	return t.next.RoundTrip(request)
+}
+
+func proxyError(w http.ResponseWriter, request *http.Request, err error) {
+	log := logger.FromCtx(request.Context())
+	if errors.Is(err, errNoIdentity) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Error().Err(err).Msg("Failed to reach the upstream")
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+type proxyOptions struct {
+	minter     IdentityMinter
+	extractors auth.Extractors
+}
+
+type ProxyOption func(*proxyOptions)
+
+// WithIdentityMinter forwards a gateway signed identity token instead of the raw user token
+func WithIdentityMinter(minter IdentityMinter) ProxyOption {
+	return func(o *proxyOptions) {
+		o.minter = minter
+	}
+}
+
+// WithExtractors sets where the token is read from when the request wasn't authenticated by the auth middleware
+func WithExtractors(extractors auth.Extractors) ProxyOption {
+	return func(o *proxyOptions) {
+		o.extractors = extractors
+	}
+}
+
+func GetProxy(config *config.Config, jwtParser jwt.Parser, opts ...ProxyOption) *httputil.ReverseProxy {
+	options := proxyOptions{extractors: auth.DefaultExtractors(config.AuthMode)}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	proxy := &httputil.ReverseProxy{Director: func(request *http.Request) {
 		request.URL.Scheme = "http"
 		request.URL.Host = config.ProxyURL.Host
+		sanitizeHeaders(request, config)
 		addUserAgentHeader(request, config)
-		addRemoteIP(request)
-		addJWTData(request, jwtParser, config.AuthMode)
+		addRemoteIP(request, config.TrustedProxyHops)
+		addJWTData(request, jwtParser, config, options)
 		addTraceHeaders(request)
-		// log all headers
-		for name, headers := range request.Header {
-			for _, h := range headers {
-				fmt.Printf("Header: %v, Value: %v\n", name, h)
-			}
+		// names only, the values carry the user's credentials
+		names := make([]string, 0, len(request.Header))
+		for name := range request.Header {
+			names = append(names, name)
 		}
+		log := logger.Get()
+		log.Debug().Strs("headers", names).Msg("Proxying request")
 		if config.OverrideOrigin != nil && request.Header.Get("Origin") != *config.OverrideOrigin {
 			request.Header.Set("Origin", *config.OverrideOrigin)
 		}
 	}}
-}
+	if options.minter != nil {
+		proxy.Transport = identityTransport{next: http.DefaultTransport}
+		proxy.ErrorHandler = proxyError
+	}
 
-func addJWTData(request *http.Request, parser jwt.Parser, authMode string) {
-	var token string
+	return proxy
+}
 
-	switch authMode {
-	case "cookie":
-		// Only check cookie
-		accessTokenCookie, err := request.Cookie("access_token")
-		if err != nil {
-			return
-		}
-		token = accessTokenCookie.Value
-	case "header":
-		// Only check Authorization header
-		authorizationHeader := request.Header.Get("Authorization")
-		if authorizationHeader != "" && strings.HasPrefix(authorizationHeader, "Bearer ") {
-			token = authorizationHeader[len("Bearer "):]
-		}
-	default: // "both" or any other value defaults to both
-		// First, try to get token from Authorization header
-		authorizationHeader := request.Header.Get("Authorization")
-		if authorizationHeader != "" && strings.HasPrefix(authorizationHeader, "Bearer ") {
-			token = authorizationHeader[len("Bearer "):]
-		} else {
-			// Fallback to cookie if Authorization header is not present
-			accessTokenCookie, err := request.Cookie("access_token")
-			if err != nil {
-				return
-			}
-			token = accessTokenCookie.Value
-		}
+func addJWTData(request *http.Request, parser jwt.Parser, cfg *config.Config, options proxyOptions) {
+	// the auth middleware already verified the token, only parse when running without it
+	result, ok := auth.FromContext(request.Context())
+	if !ok {
+		result = auth.Authenticate(request, parser, options.extractors)
 	}
 
-	if token == "" {
-		return
+	if options.minter != nil {
+		// subgraphs identify the user by the minted token only, the user's own token stays at the gateway
+		options.extractors.Strip(request)
+	} else {
+		// x-raw-token carries the token, a copy in the URL or the protocol list is only a leak
+		options.extractors.StripWebSocket(request)
 	}
-
-	info, err := parser.Parse(token)
-	if err != nil {
+	if result.Claims == nil {
 		return
 	}
-	if info.Subject != nil {
+	info := result.Claims
+	request.Header.Set("x-auth-method", result.Method)
+	if result.Method == auth.MethodMTLS {
+		request.Header.Set(servicePrincipalHeader, result.Principal)
+	} else if info.Subject != nil {
 		request.Header.Set("x-user-id", *info.Subject)
 	}
 	if info.Purpose != nil {
 		request.Header.Set("x-token-purpose", *info.Purpose)
 	}
-	request.Header.Add("x-raw-token", token)
+	addClaimHeaders(request, info, cfg.ClaimHeaders)
+
+	if options.minter == nil {
+		// API key requests carry no token, the key itself is never forwarded
+		if result.Token != "" {
+			request.Header.Add("x-raw-token", result.Token)
+		}
+		return
+	}
+	internalToken, err := options.minter.Mint(request.Context(), info)
+	if err != nil {
+		log := logger.FromCtx(request.Context())
+		log.Error().Err(err).Msg("Failed to mint internal identity token")
+		// the Director can't answer, the transport rejects the request instead
+		*request = *request.WithContext(context.WithValue(request.Context(), noIdentityKey{}, err))
+		return
+	}
+	request.Header.Set(identity.Header, internalToken)
 }
 
-func addRemoteIP(request *http.Request) {
-	request.Header.Set("x-remote-ip", request.Header.Get("x-forwarded-for"))
+// addRemoteIP sets the client address, X-Forwarded-For is client controlled and only the entries
+// appended by the trusted proxies in front of the gateway are believed
+func addRemoteIP(request *http.Request, trustedHops int) {
+	var chain []string
+	for _, header := range request.Header.Values("x-forwarded-for") {
+		for _, address := range strings.Split(header, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				chain = append(chain, address)
+			}
+		}
+	}
+	peer, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		peer = request.RemoteAddr
+	}
+	chain = append(chain, peer)
+
+	// every trusted proxy appended the address it received the request from
+	client := max(len(chain)-1-max(trustedHops, 0), 0)
+	request.Header.Set("x-remote-ip", chain[client])
 	request.Header.Del("x-forwarded-for")
 }
 
@@ -1,14 +1,19 @@
 package handlers_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"github.com/stretchr/testify/assert"
 	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/auth"
 	"github.com/weeb-vip/gateway-proxy/internal/handlers"
 	"github.com/weeb-vip/gateway-proxy/internal/jwt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"testing"
 )
 
@@ -27,6 +32,23 @@ func (r mockParser) Parse(token string) (*jwt.ParsedJWT, error) {
 }
 
 func TestGetProxy(t *testing.T) {
+	t.Run("doesn't print the request's credentials", func(t *testing.T) {
+		stdout := os.Stdout
+		reader, writer, err := os.Pipe()
+		assert.NoError(t, err)
+		os.Stdout = writer
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer secret-token")
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "secret-cookie"})
+		proxyURL, _ := url.Parse("http://localhost:8080")
+		handlers.GetProxy(&config.Config{ProxyURL: proxyURL}, mockParser{}).Director(request)
+		os.Stdout = stdout
+		_ = writer.Close()
+		printed, _ := io.ReadAll(reader)
+
+		assert.NotContains(t, string(printed), "secret-token")
+		assert.NotContains(t, string(printed), "secret-cookie")
+	})
 	t.Run("it changes the host to target host", func(t *testing.T) {
 		request := httptest.NewRequest("GET", "/", nil)
 		request.URL.Host = "https://example.com"
@@ -44,13 +66,33 @@ func TestGetProxy(t *testing.T) {
 
 		assert.Equal(t, "my-application", request.Header.Get("x-user-agent"))
 	})
-	t.Run("adds x-remote-ip", func(t *testing.T) {
+	t.Run("adds x-remote-ip from the peer address, ignoring X-Forwarded-For", func(t *testing.T) {
 		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = "10.0.0.7:51234"
 		request.Header.Add("x-forwarded-for", "192.168.1.1")
 		proxyURL, _ := url.Parse("http://localhost:8080")
 		handlers.GetProxy(&config.Config{ProxyAddress: "http://localhost:8080", ProxyURL: proxyURL}, mockParser{}).Director(request)
 
-		assert.Equal(t, "192.168.1.1", request.Header.Get("x-remote-ip"))
+		assert.Equal(t, "10.0.0.7", request.Header.Get("x-remote-ip"))
+		assert.Empty(t, request.Header.Values("x-forwarded-for"))
+	})
+	t.Run("adds x-remote-ip from the entries of trusted proxies", func(t *testing.T) {
+		proxyURL, _ := url.Parse("http://localhost:8080")
+		send := func(hops int, forwardedFor ...string) string {
+			request := httptest.NewRequest("GET", "/", nil)
+			request.RemoteAddr = "10.0.0.7:51234"
+			for _, value := range forwardedFor {
+				request.Header.Add("x-forwarded-for", value)
+			}
+			handlers.GetProxy(&config.Config{ProxyURL: proxyURL, TrustedProxyHops: hops}, mockParser{}).Director(request)
+			return request.Header.Get("x-remote-ip")
+		}
+
+		// the client spoofed 6.6.6.6, the load balancer appended the address it saw
+		assert.Equal(t, "203.0.113.9", send(1, "6.6.6.6, 203.0.113.9"))
+		assert.Equal(t, "203.0.113.9", send(2, "6.6.6.6", "203.0.113.9, 10.0.0.3"))
+		// fewer entries than trusted hops, the first one is the closest to the client
+		assert.Equal(t, "203.0.113.9", send(3, "203.0.113.9"))
 	})
 	t.Run("adds user agent of proxy", func(t *testing.T) {
 		request := httptest.NewRequest("GET", "/", nil)
@@ -84,8 +126,298 @@ func TestGetProxy(t *testing.T) {
 		assert.Equal(t, "Purpose", request.Header.Get("x-token-purpose"))
 		assert.Equal(t, "123", request.Header.Get("x-raw-token"))
 	})
+
+	t.Run("uses the result of the auth middleware instead of parsing again", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer 123")
+		request = request.WithContext(auth.WithResult(request.Context(), &auth.Result{
+			Method: auth.MethodJWT,
+			Token:  "123",
+			Claims: &jwt.ParsedJWT{Subject: getPointer("Subject")},
+		}))
+		proxyURL, _ := url.Parse("http://localhost:8080")
+		handlers.GetProxy(&config.Config{ProxyURL: proxyURL}, mockParser{resultFactory: func(token string) (*jwt.ParsedJWT, error) {
+			panic("token parsed twice")
+		}}).Director(request)
+
+		assert.Equal(t, "Subject", request.Header.Get("x-user-id"))
+		assert.Equal(t, "123", request.Header.Get("x-raw-token"))
+	})
+
+	t.Run("does not add identity headers for a token that failed verification", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer expired")
+		proxyURL, _ := url.Parse("http://localhost:8080")
+		handlers.GetProxy(&config.Config{ProxyURL: proxyURL}, mockParser{err: errors.New("token is expired")}).Director(request)
+
+		assert.Empty(t, request.Header.Get("x-user-id"))
+		assert.Empty(t, request.Header.Get("x-raw-token"))
+	})
+}
+
+func TestGetProxyHeaderSanitization(t *testing.T) {
+	proxyURL, _ := url.Parse("http://localhost:8080")
+
+	t.Run("strips spoofed identity headers from anonymous requests", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("x-user-id", "admin")
+		request.Header.Set("x-token-purpose", "access")
+		request.Header.Set("x-raw-token", "forged")
+		request.Header.Set("x-remote-ip", "10.0.0.1")
+		handlers.GetProxy(&config.Config{ProxyURL: proxyURL}, mockParser{}).Director(request)
+
+		assert.Empty(t, request.Header.Values("x-user-id"))
+		assert.Empty(t, request.Header.Values("x-token-purpose"))
+		assert.Empty(t, request.Header.Values("x-raw-token"))
+		assert.Equal(t, []string{"192.0.2.1"}, request.Header.Values("x-remote-ip"))
+	})
+	t.Run("strips spoofed identity headers when the token fails verification", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer forged")
+		request.Header.Set("X-User-Id", "admin")
+		handlers.GetProxy(&config.Config{ProxyURL: proxyURL}, mockParser{err: errors.New("invalid token")}).Director(request)
+
+		assert.Empty(t, request.Header.Values("x-user-id"))
+	})
+	t.Run("replaces spoofed identity headers with the verified ones", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer 123")
+		request.Header.Add("x-user-id", "admin")
+		request.Header.Add("x-user-id", "root")
+		request.Header.Add("x-raw-token", "forged")
+		handlers.GetProxy(&config.Config{ProxyURL: proxyURL}, mockParser{token: &jwt.ParsedJWT{Subject: getPointer("Subject")}}).Director(request)
+
+		assert.Equal(t, []string{"Subject"}, request.Header.Values("x-user-id"))
+		assert.Equal(t, []string{"123"}, request.Header.Values("x-raw-token"))
+	})
+	t.Run("strips configured headers and everything under the reserved prefix", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("x-tenant-id", "other-tenant")
+		request.Header.Set("X-Gateway-Roles", "admin")
+		request.Header.Set("x-gateway-anything", "value")
+		request.Header.Set("x-custom", "kept")
+		handlers.GetProxy(&config.Config{
+			ProxyURL:             proxyURL,
+			StrippedHeaders:      []string{"x-tenant-id"},
+			ReservedHeaderPrefix: "x-gateway-",
+		}, mockParser{}).Director(request)
+
+		assert.Empty(t, request.Header.Values("x-tenant-id"))
+		assert.Empty(t, request.Header.Values("x-gateway-roles"))
+		assert.Empty(t, request.Header.Values("x-gateway-anything"))
+		assert.Equal(t, "kept", request.Header.Get("x-custom"))
+	})
+}
+
+func TestGetProxyClaimHeaders(t *testing.T) {
+	proxyURL, _ := url.Parse("http://localhost:8080")
+	cfg := &config.Config{
+		ProxyURL: proxyURL,
+		ClaimHeaders: map[string]string{
+			"tenant_id":          "x-tenant-id",
+			"email_verified":     "x-email-verified",
+			"realm_access.roles": "x-user-roles",
+			"scope":              "x-user-scopes",
+			"missing":            "x-missing",
+			"address":            "x-address",
+		},
+	}
+	parsed := &jwt.ParsedJWT{
+		Subject: getPointer("Subject"),
+		Claims: map[string]any{
+			"tenant_id":      "tenant-1",
+			"email_verified": true,
+			"realm_access":   map[string]any{"roles": []any{"admin", "editor"}},
+			"scope":          "read write",
+			"address":        map[string]any{"country": "JP"},
+		},
+	}
+
+	t.Run("forwards mapped claims, JSON encoding arrays and objects", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer 123")
+		handlers.GetProxy(cfg, mockParser{token: parsed}).Director(request)
+
+		assert.Equal(t, "tenant-1", request.Header.Get("x-tenant-id"))
+		assert.Equal(t, "true", request.Header.Get("x-email-verified"))
+		assert.Equal(t, `["admin","editor"]`, request.Header.Get("x-user-roles"))
+		assert.Equal(t, "read write", request.Header.Get("x-user-scopes"))
+		assert.Equal(t, `{"country":"JP"}`, request.Header.Get("x-address"))
+		assert.Empty(t, request.Header.Values("x-missing"))
+	})
+	t.Run("strips client supplied values for mapped headers", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("x-tenant-id", "other-tenant")
+		request.Header.Set("x-user-roles", `["admin"]`)
+		handlers.GetProxy(cfg, mockParser{}).Director(request)
+
+		assert.Empty(t, request.Header.Values("x-tenant-id"))
+		assert.Empty(t, request.Header.Values("x-user-roles"))
+	})
+	t.Run("never forwards claim values that would break the header", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer 123")
+		handlers.GetProxy(cfg, mockParser{token: &jwt.ParsedJWT{
+			Claims: map[string]any{"tenant_id": "tenant-1\r\nx-user-id: admin"},
+		}}).Director(request)
+
+		assert.Empty(t, request.Header.Values("x-tenant-id"))
+	})
 }
 
 func getPointer[T any](input T) *T {
 	return &input
 }
+
+type mockMinter struct {
+	token string
+	err   error
+}
+
+func (m mockMinter) Mint(_ context.Context, _ *jwt.ParsedJWT) (string, error) {
+	return m.token, m.err
+}
+
+func TestGetProxyIdentityToken(t *testing.T) {
+	proxyURL, _ := url.Parse("http://localhost:8080")
+	cfg := &config.Config{ProxyURL: proxyURL}
+	parser := mockParser{token: &jwt.ParsedJWT{Subject: getPointer("Subject")}}
+
+	t.Run("forwards the internal token instead of the raw token", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer 123")
+		handlers.GetProxy(cfg, parser, handlers.WithIdentityMinter(mockMinter{token: "internal"})).Director(request)
+
+		assert.Equal(t, "internal", request.Header.Get("x-internal-token"))
+		assert.Equal(t, "Subject", request.Header.Get("x-user-id"))
+		assert.Empty(t, request.Header.Values("x-raw-token"))
+	})
+	t.Run("rejects the request when minting fails", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("the request reached the upstream without its identity")
+		}))
+		defer upstream.Close()
+		upstreamURL, _ := url.Parse(upstream.URL)
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", "Bearer 123")
+		recorder := httptest.NewRecorder()
+
+		handlers.GetProxy(&config.Config{ProxyURL: upstreamURL}, parser, handlers.WithIdentityMinter(mockMinter{err: errors.New("signing failed")})).ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	})
+	t.Run("strips the user's token from every configured source", func(t *testing.T) {
+		extractors, err := auth.NewExtractors([]config.TokenSource{
+			{Type: "header"},
+			{Type: "cookie"},
+			{Type: "query"},
+			{Type: "websocket-protocol"},
+		}, "")
+		assert.NoError(t, err)
+		request := httptest.NewRequest("GET", "/graphql?access_token=123&debug=1", nil)
+		request.Header.Set("Upgrade", "websocket")
+		request.Header.Set("Authorization", "Bearer 123")
+		request.Header.Set("Sec-WebSocket-Protocol", "graphql-transport-ws, access_token.123")
+		request.AddCookie(&http.Cookie{Name: "access_token", Value: "123"})
+		request.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+
+		handlers.GetProxy(cfg, parser, handlers.WithIdentityMinter(mockMinter{token: "internal"}), handlers.WithExtractors(extractors)).Director(request)
+
+		assert.Equal(t, "internal", request.Header.Get("x-internal-token"))
+		assert.Empty(t, request.Header.Get("Authorization"))
+		assert.Equal(t, "theme=dark", request.Header.Get("Cookie"))
+		assert.Equal(t, "debug=1", request.URL.RawQuery)
+		assert.Equal(t, "graphql-transport-ws", request.Header.Get("Sec-WebSocket-Protocol"))
+	})
+	t.Run("strips client supplied internal tokens", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("x-internal-token", "forged")
+		handlers.GetProxy(cfg, mockParser{}, handlers.WithIdentityMinter(mockMinter{token: "internal"})).Director(request)
+
+		assert.Empty(t, request.Header.Values("x-internal-token"))
+	})
+}
+
+func TestGetProxyExtractors(t *testing.T) {
+	proxyURL, _ := url.Parse("http://localhost:8080")
+
+	t.Run("reads the token from the configured sources when running without the auth middleware", func(t *testing.T) {
+		extractors, err := auth.NewExtractors([]config.TokenSource{{Type: "cookie", Name: "session"}}, "both")
+		assert.NoError(t, err)
+		request := httptest.NewRequest("GET", "/", nil)
+		request.AddCookie(&http.Cookie{Name: "session", Value: "123"})
+		handlers.GetProxy(&config.Config{ProxyURL: proxyURL}, mockParser{resultFactory: func(token string) (*jwt.ParsedJWT, error) {
+			return &jwt.ParsedJWT{Subject: getPointer(token)}, nil
+		}}, handlers.WithExtractors(extractors)).Director(request)
+
+		assert.Equal(t, "123", request.Header.Get("x-user-id"))
+	})
+	t.Run("removes query and protocol tokens from the forwarded upgrade", func(t *testing.T) {
+		extractors, err := auth.NewExtractors([]config.TokenSource{{Type: "query"}, {Type: "websocket-protocol"}}, "")
+		assert.NoError(t, err)
+		request := httptest.NewRequest("GET", "/graphql?access_token=123&debug=1", nil)
+		request.Header.Set("Upgrade", "websocket")
+		request.Header.Set("Sec-WebSocket-Protocol", "graphql-transport-ws, access_token.123")
+		handlers.GetProxy(&config.Config{ProxyURL: proxyURL}, mockParser{resultFactory: func(token string) (*jwt.ParsedJWT, error) {
+			return &jwt.ParsedJWT{Subject: getPointer(token)}, nil
+		}}, handlers.WithExtractors(extractors)).Director(request)
+
+		assert.Equal(t, "123", request.Header.Get("x-user-id"))
+		assert.Equal(t, "123", request.Header.Get("x-raw-token"))
+		assert.Equal(t, "debug=1", request.URL.RawQuery)
+		assert.Equal(t, "graphql-transport-ws", request.Header.Get("Sec-WebSocket-Protocol"))
+	})
+}
+
+func TestGetProxyAPIKeys(t *testing.T) {
+	proxyURL, _ := url.Parse("http://localhost:8080")
+	cfg := &config.Config{ProxyURL: proxyURL, APIKeysEnabled: true, APIKeyHeader: "X-API-Key"}
+
+	t.Run("forwards the principal but neither the key nor a raw token", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("X-API-Key", "secret-key")
+		request = request.WithContext(auth.WithResult(request.Context(), &auth.Result{
+			Method: auth.MethodAPIKey,
+			Claims: &jwt.ParsedJWT{Subject: getPointer("service:importer")},
+		}))
+		handlers.GetProxy(cfg, mockParser{}).Director(request)
+
+		assert.Equal(t, "service:importer", request.Header.Get("x-user-id"))
+		assert.Equal(t, "api_key", request.Header.Get("x-auth-method"))
+		assert.Empty(t, request.Header.Values("X-API-Key"))
+		assert.Empty(t, request.Header.Values("x-raw-token"))
+	})
+	t.Run("strips client supplied auth methods", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("x-auth-method", "api_key")
+		handlers.GetProxy(cfg, mockParser{}).Director(request)
+
+		assert.Empty(t, request.Header.Values("x-auth-method"))
+	})
+}
+
+func TestGetProxyCertificates(t *testing.T) {
+	proxyURL, _ := url.Parse("http://localhost:8080")
+	cfg := &config.Config{ProxyURL: proxyURL}
+
+	t.Run("forwards the certificate principal apart from user ids", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request = request.WithContext(auth.WithResult(request.Context(), &auth.Result{
+			Method:    auth.MethodMTLS,
+			Principal: "user-1",
+			Claims:    &jwt.ParsedJWT{Subject: getPointer("mtls:user-1")},
+		}))
+		handlers.GetProxy(cfg, mockParser{}).Director(request)
+
+		assert.Equal(t, "user-1", request.Header.Get("x-service-principal"))
+		assert.Equal(t, "mtls", request.Header.Get("x-auth-method"))
+		assert.Empty(t, request.Header.Values("x-user-id"))
+	})
+	t.Run("strips client supplied service principals", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("x-service-principal", "forged")
+		handlers.GetProxy(cfg, mockParser{}).Director(request)
+
+		assert.Empty(t, request.Header.Values("x-service-principal"))
+	})
+}
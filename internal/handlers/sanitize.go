@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/identity"
+)
+
+// servicePrincipalHeader carries the certificate principal of mTLS clients, x-user-id only ever holds user ids
+const servicePrincipalHeader = "x-service-principal"
+
+// trustedHeaders are only ever set by the gateway itself, subgraphs rely on them to identify the caller.
+// They are always stripped from inbound requests, the configured deny-list only adds to them.
+var trustedHeaders = []string{
+	"x-user-id",
+	"x-token-purpose",
+	"x-raw-token",
+	"x-auth-method",
+	servicePrincipalHeader,
+	identity.Header,
+	"x-remote-ip",
+}
+
+// sanitizeHeaders removes client supplied values for headers the gateway is responsible for,
+// it has to run before any trusted value is injected into the request
+func sanitizeHeaders(request *http.Request, cfg *config.Config) {
+	for _, name := range trustedHeaders {
+		request.Header.Del(name)
+	}
+	if cfg.APIKeysEnabled {
+		// the key is a credential of the gateway's own, subgraphs only ever see the principal
+		request.Header.Del(cfg.APIKeyHeader)
+	}
+	for _, name := range cfg.StrippedHeaders {
+		request.Header.Del(name)
+	}
+	for _, name := range cfg.ClaimHeaders {
+		request.Header.Del(name)
+	}
+
+	if cfg.ReservedHeaderPrefix == "" {
+		return
+	}
+	prefix := strings.ToLower(cfg.ReservedHeaderPrefix)
+	for name := range request.Header {
+		if strings.HasPrefix(strings.ToLower(name), prefix) {
+			delete(request.Header, name)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/weeb-vip/gateway-proxy/internal/poller"
+)
+
+// KeysCheck fails without any verification key, or when no key source fetched successfully within maxAge.
+// Sources started from a snapshot count as fresh until their next fetch
+func KeysCheck(keyManager poller.KeyManager, maxAge time.Duration) Check {
+	return func(context.Context) error {
+		var keys int
+		var lastSuccess time.Time
+		for _, status := range keyManager.Status() {
+			keys += status.Keys
+			fetched := status.LastSuccess
+			if status.Restored {
+				fetched = time.Now()
+			}
+			if fetched.After(lastSuccess) {
+				lastSuccess = fetched
+			}
+		}
+
+		if keys == 0 {
+			return errors.New("no verification keys loaded")
+		}
+		if maxAge > 0 && time.Since(lastSuccess) > maxAge {
+			return fmt.Errorf("keys were last fetched at %s", lastSuccess.UTC().Format(time.RFC3339))
+		}
+
+		return nil
+	}
+}
+
+func RedisCheck(client redis.UniversalClient) Check {
+	return func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}
+}
+
+// UpstreamCheck fails when url can't be reached or answers with a server error
+func UpstreamCheck(url string) Check {
+	client := &http.Client{
+		// a redirect answer is enough to know the upstream is up
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return func(ctx context.Context) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("upstream answered %d", response.StatusCode)
+		}
+
+		return nil
+	}
+}
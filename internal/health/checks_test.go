@@ -0,0 +1,99 @@
+package health_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/health"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"github.com/weeb-vip/gateway-proxy/internal/poller"
+)
+
+type keyManager struct {
+	statuses []poller.Status
+}
+
+func (k keyManager) Fetch() error                                          { return nil }
+func (k keyManager) SetupBackgroundPolling(context.Context, time.Duration) {}
+func (k keyManager) FindKeyByID(string) (*keys.Key, error)                 { return nil, nil }
+func (k keyManager) Stop()                                                 {}
+func (k keyManager) Status() []poller.Status                               { return k.statuses }
+func (k keyManager) Watch() (<-chan keys.Event, func())                    { return nil, func() {} }
+
+func TestKeysCheck(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("passes with keys fetched recently", func(t *testing.T) {
+		check := health.KeysCheck(keyManager{statuses: []poller.Status{
+			{Source: "stale", Keys: 1, LastSuccess: time.Now().Add(-2 * time.Hour), LastError: "timeout"},
+			{Source: "fresh", Keys: 2, LastSuccess: time.Now()},
+		}}, time.Hour)
+
+		assert.NoError(t, check(ctx))
+	})
+	t.Run("fails without keys", func(t *testing.T) {
+		check := health.KeysCheck(keyManager{statuses: []poller.Status{{Source: "empty", LastSuccess: time.Now()}}}, time.Hour)
+
+		assert.EqualError(t, check(ctx), "no verification keys loaded")
+	})
+	t.Run("fails when no source fetched within the max age", func(t *testing.T) {
+		check := health.KeysCheck(keyManager{statuses: []poller.Status{
+			{Source: "stale", Keys: 1, LastSuccess: time.Now().Add(-2 * time.Hour)},
+		}}, time.Hour)
+
+		assert.ErrorContains(t, check(ctx), "keys were last fetched at")
+	})
+	t.Run("passes for a source started from an old snapshot until its next fetch", func(t *testing.T) {
+		check := health.KeysCheck(keyManager{statuses: []poller.Status{
+			{Source: "restored", Keys: 1, LastSuccess: time.Now().Add(-2 * time.Hour), LastError: "timeout", Restored: true},
+		}}, time.Hour)
+
+		assert.NoError(t, check(ctx))
+	})
+}
+
+func TestRedisCheck(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	check := health.RedisCheck(client)
+
+	t.Run("passes while redis answers", func(t *testing.T) {
+		assert.NoError(t, check(context.Background()))
+	})
+	t.Run("fails once redis is gone", func(t *testing.T) {
+		server.Close()
+
+		assert.Error(t, check(context.Background()))
+	})
+}
+
+func TestUpstreamCheck(t *testing.T) {
+	status := http.StatusOK
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+	check := health.UpstreamCheck(upstream.URL)
+
+	t.Run("passes below server errors", func(t *testing.T) {
+		for _, status = range []int{http.StatusOK, http.StatusFound, http.StatusNotFound} {
+			assert.NoError(t, check(context.Background()))
+		}
+	})
+	t.Run("fails on server errors", func(t *testing.T) {
+		status = http.StatusBadGateway
+
+		assert.EqualError(t, check(context.Background()), "upstream answered 502")
+	})
+	t.Run("fails when unreachable", func(t *testing.T) {
+		require.Error(t, health.UpstreamCheck("http://127.0.0.1:1")(context.Background()))
+	})
+}
@@ -0,0 +1,136 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+	StatusDraining = "draining"
+	StatusOK       = "ok"
+	StatusFailed   = "failed"
+
+	checkTimeout = 2 * time.Second
+)
+
+// Check reports whether a dependency can be used, a nil error means it can
+type Check func(ctx context.Context) error
+
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the body of the readiness probe, with the outcome of every check
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Readiness tells load balancers whether to send new requests. It fails while a check fails,
+// and for good once shutdown begins
+type Readiness struct {
+	draining atomic.Bool
+	checks   map[string]Check
+	timeout  time.Duration
+}
+
+type Option func(r *Readiness)
+
+// Drain makes the readiness probe fail, in-flight and new requests are still served until the server stops
+func (r *Readiness) Drain() {
+	r.draining.Store(true)
+}
+
+// Report runs every check concurrently, a check that doesn't answer within the timeout fails
+func (r *Readiness) Report(ctx context.Context) Report {
+	if r.draining.Load() {
+		return Report{Status: StatusDraining}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusReady, Checks: make(map[string]CheckResult, len(r.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range r.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := CheckResult{Status: StatusOK}
+			if err := check(ctx); err != nil {
+				result = CheckResult{Status: StatusFailed, Error: err.Error()}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusNotReady
+			}
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
+
+// Handler answers 200 while ready and 503 otherwise, with the report as body
+func (r *Readiness) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.Report(req.Context())
+		code := http.StatusOK
+		if report.Status != StatusReady {
+			code = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, code, report)
+	})
+}
+
+// Liveness answers 200 as long as the process serves requests, dependencies are left to the readiness probe
+// so an outage elsewhere doesn't get the gateway restarted
+func Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// WithCheck adds a named check to the readiness probe
+func WithCheck(name string, check Check) Option {
+	return func(r *Readiness) {
+		r.checks[name] = check
+	}
+}
+
+// WithTimeout bounds how long the checks of one probe may take together
+func WithTimeout(timeout time.Duration) Option {
+	return func(r *Readiness) {
+		if timeout > 0 {
+			r.timeout = timeout
+		}
+	}
+}
+
+func NewReadiness(opts ...Option) *Readiness {
+	r := &Readiness{checks: make(map[string]Check), timeout: checkTimeout}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
@@ -0,0 +1,92 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/health"
+)
+
+func probe(t *testing.T, handler http.Handler) (int, health.Report) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+	var report health.Report
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	return recorder.Code, report
+}
+
+func healthy(context.Context) error {
+	return nil
+}
+
+func TestReadiness(t *testing.T) {
+	t.Run("is ready when every check passes", func(t *testing.T) {
+		readiness := health.NewReadiness(health.WithCheck("keys", healthy), health.WithCheck("redis", healthy))
+
+		code, report := probe(t, readiness.Handler())
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, health.Report{Status: health.StatusReady, Checks: map[string]health.CheckResult{
+			"keys":  {Status: health.StatusOK},
+			"redis": {Status: health.StatusOK},
+		}}, report)
+	})
+	t.Run("is not ready while a check fails and reports which one", func(t *testing.T) {
+		readiness := health.NewReadiness(
+			health.WithCheck("keys", healthy),
+			health.WithCheck("redis", func(context.Context) error { return errors.New("connection refused") }),
+		)
+
+		code, report := probe(t, readiness.Handler())
+
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.StatusNotReady, report.Status)
+		assert.Equal(t, health.StatusOK, report.Checks["keys"].Status)
+		assert.Equal(t, health.CheckResult{Status: health.StatusFailed, Error: "connection refused"}, report.Checks["redis"])
+	})
+	t.Run("fails checks that outlast the timeout", func(t *testing.T) {
+		readiness := health.NewReadiness(
+			health.WithTimeout(10*time.Millisecond),
+			health.WithCheck("upstream", func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}),
+		)
+
+		code, report := probe(t, readiness.Handler())
+
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.StatusFailed, report.Checks["upstream"].Status)
+	})
+	t.Run("reports draining once shutdown begins, without running the checks", func(t *testing.T) {
+		readiness := health.NewReadiness(health.WithCheck("keys", func(context.Context) error {
+			t.Error("checks must not run while draining")
+			return nil
+		}))
+
+		readiness.Drain()
+		code, report := probe(t, readiness.Handler())
+
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.Report{Status: health.StatusDraining}, report)
+	})
+}
+
+func TestLiveness(t *testing.T) {
+	t.Run("answers ok", func(t *testing.T) {
+		code, report := probe(t, health.Liveness())
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, health.Report{Status: health.StatusOK}, report)
+	})
+}
@@ -0,0 +1,136 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v4"
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// registeredClaims are always set by the minter, never copied from the user token
+var registeredClaims = []string{"iss", "aud", "iat", "nbf", "exp", "jti"}
+
+// Mint creates an identity token for the verified user, it never outlives the user token
+func (m *Minter) Mint(ctx context.Context, user *jwt.ParsedJWT) (string, error) {
+	now := m.now()
+	expiresAt := now.Add(m.ttl)
+	if !user.ExpiresAt.IsZero() && user.ExpiresAt.Before(expiresAt) {
+		expiresAt = user.ExpiresAt
+	}
+
+	claims := jwtlib.MapClaims{}
+	for name, value := range user.Claims {
+		claims[name] = value
+	}
+	for _, name := range registeredClaims {
+		delete(claims, name)
+	}
+	if user.Subject != nil {
+		claims["sub"] = *user.Subject
+	}
+	if user.Issuer != "" {
+		claims["original_iss"] = user.Issuer
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		claims["trace_id"] = spanContext.TraceID().String()
+	}
+	claims["iss"] = m.issuer
+	claims["aud"] = m.audience
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = expiresAt.Unix()
+
+	token := jwtlib.NewWithClaims(jwtlib.SigningMethodRS256, claims)
+	token.Header["kid"] = m.keyID
+
+	return token.SignedString(m.key)
+}
+
+// JWKS returns the key set subgraphs use to verify identity tokens
+func (m *Minter) JWKS() (keys.JSONWebKeySet, error) {
+	jwk, err := keys.NewJSONWebKey(m.keyID, jwtlib.SigningMethodRS256.Alg(), &m.key.PublicKey)
+	if err != nil {
+		return keys.JSONWebKeySet{}, err
+	}
+
+	return keys.JSONWebKeySet{Keys: []keys.JSONWebKey{jwk}}, nil
+}
+
+// JWKSHandler publishes the gateway's public key
+func (m *Minter) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set, err := m.JWKS()
+		if err != nil {
+			http.Error(w, "failed to encode key set", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_ = json.NewEncoder(w).Encode(set)
+	})
+}
+
+func keyID(publicKey *rsa.PublicKey) (string, error) {
+	marshalled, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(marshalled)
+
+	return base64.RawURLEncoding.EncodeToString(hash[:16]), nil
+}
+
+func loadKey(path string, ephemeral bool) (*rsa.PrivateKey, error) {
+	if path == "" {
+		// every replica would publish its own key and tokens would only verify against the replica that minted them
+		if !ephemeral {
+			return nil, errors.New("no key file configured, set CONFIG__INTERNAL_TOKEN_KEY_FILE")
+		}
+		log := logger.Get()
+		log.Warn().Msg("No internal token key file configured, generating an ephemeral signing key for development")
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwtlib.ParseRSAPrivateKeyFromPEM(body)
+}
+
+// NewMinter loads the signing key configured for internal identity tokens
+func NewMinter(cfg *config.Config) (*Minter, error) {
+	key, err := loadKey(cfg.InternalTokenKeyFile, cfg.InternalTokenDevKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load internal token key: %w", err)
+	}
+	kid, err := keyID(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Minter{
+		key:      key,
+		keyID:    kid,
+		issuer:   cfg.InternalTokenIssuer,
+		audience: cfg.InternalTokenAudience,
+		ttl:      time.Duration(cfg.InternalTokenTTLSeconds) * time.Second,
+		now:      time.Now,
+	}, nil
+}
@@ -0,0 +1,143 @@
+package identity_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/identity"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"github.com/weeb-vip/gateway-proxy/internal/poller"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func getPointer[T any](input T) *T {
+	return &input
+}
+
+func newConfig() *config.Config {
+	return &config.Config{
+		InternalTokenIssuer:     "gateway-proxy",
+		InternalTokenAudience:   "subgraphs",
+		InternalTokenTTLSeconds: 60,
+		// the key is generated per test, nothing verifies across replicas here
+		InternalTokenDevKey: true,
+	}
+}
+
+// verifier checks minted tokens the way a subgraph would, through the published key set
+func verifier(t *testing.T, minter *identity.Minter) jwt.Parser {
+	server := httptest.NewServer(minter.JWKSHandler())
+	t.Cleanup(server.Close)
+
+	keyManager, err := poller.Keys(keys.NewJWKSFetcher(server.URL))
+	require.NoError(t, err)
+
+	return jwt.NewParser(keyManager, jwt.WithIssuers("gateway-proxy"), jwt.WithAudiences("subgraphs"))
+}
+
+func TestMinter(t *testing.T) {
+	user := &jwt.ParsedJWT{
+		Subject:   getPointer("user-1"),
+		Issuer:    "https://auth.weeb.vip",
+		ExpiresAt: time.Now().Add(time.Hour),
+		Claims: map[string]any{
+			"sub":   "user-1",
+			"iss":   "https://auth.weeb.vip",
+			"aud":   "weeb-vip",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"roles": []any{"admin"},
+		},
+	}
+
+	t.Run("mints a token subgraphs can verify with the published key", func(t *testing.T) {
+		minter, err := identity.NewMinter(newConfig())
+		require.NoError(t, err)
+
+		token, err := minter.Mint(context.Background(), user)
+		require.NoError(t, err)
+
+		parsed, err := verifier(t, minter).Parse(token)
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", *parsed.Subject)
+		assert.Equal(t, "gateway-proxy", parsed.Issuer)
+		assert.Equal(t, "https://auth.weeb.vip", parsed.Claims["original_iss"])
+		assert.Equal(t, []any{"admin"}, parsed.Claims["roles"])
+		assert.WithinDuration(t, time.Now().Add(time.Minute), parsed.ExpiresAt, 2*time.Second)
+	})
+	t.Run("never outlives the user token", func(t *testing.T) {
+		minter, err := identity.NewMinter(newConfig())
+		require.NoError(t, err)
+		expiring := *user
+		expiring.ExpiresAt = time.Now().Add(10 * time.Second)
+
+		token, err := minter.Mint(context.Background(), &expiring)
+		require.NoError(t, err)
+
+		parsed, err := verifier(t, minter).Parse(token)
+		require.NoError(t, err)
+		assert.Equal(t, expiring.ExpiresAt.Unix(), parsed.ExpiresAt.Unix())
+	})
+	t.Run("carries the trace id of the request", func(t *testing.T) {
+		minter, err := identity.NewMinter(newConfig())
+		require.NoError(t, err)
+		traceID := trace.TraceID{0x0a, 0x0b, 0x0c}
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: traceID,
+			SpanID:  trace.SpanID{0x01},
+		}))
+
+		token, err := minter.Mint(ctx, user)
+		require.NoError(t, err)
+
+		claims := jwtlib.MapClaims{}
+		_, _, err = jwtlib.NewParser().ParseUnverified(token, claims)
+		require.NoError(t, err)
+		assert.Equal(t, traceID.String(), claims["trace_id"])
+	})
+	t.Run("signs with the configured key file", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "internal.pem")
+		body := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		require.NoError(t, os.WriteFile(path, body, 0o600))
+		cfg := newConfig()
+		cfg.InternalTokenKeyFile = path
+
+		minter, err := identity.NewMinter(cfg)
+		require.NoError(t, err)
+		token, err := minter.Mint(context.Background(), user)
+		require.NoError(t, err)
+
+		_, err = jwtlib.Parse(token, func(*jwtlib.Token) (any, error) {
+			return &key.PublicKey, nil
+		})
+		assert.NoError(t, err)
+	})
+	t.Run("returns an error when the key file is missing", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.InternalTokenKeyFile = filepath.Join(t.TempDir(), "missing.pem")
+
+		_, err := identity.NewMinter(cfg)
+		assert.Error(t, err)
+	})
+	t.Run("refuses to start without a key file unless an ephemeral key is allowed", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.InternalTokenDevKey = false
+
+		_, err := identity.NewMinter(cfg)
+		assert.ErrorContains(t, err, "CONFIG__INTERNAL_TOKEN_KEY_FILE")
+	})
+}
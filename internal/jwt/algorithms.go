@@ -0,0 +1,89 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// DefaultAlgorithms is used when no allow-list is configured
+var DefaultAlgorithms = []string{"RS256"}
+
+// parsePublicKey detects the key type from a PEM body, accepting PKIX, PKCS1 and certificates
+func parsePublicKey(body string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(body))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if publicKey, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return publicKey, nil
+	}
+	if publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return publicKey, nil
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.New("unsupported public key encoding")
+	}
+
+	return certificate.PublicKey, nil
+}
+
+// checkAlgorithmMatchesKey guards against algorithm confusion, a key can only verify the family it belongs to
+func checkAlgorithmMatchesKey(alg string, publicKey crypto.PublicKey) error {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if expected := curveAlgorithm(key.Curve); expected != "" && expected == alg {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if alg == "EdDSA" {
+			return nil
+		}
+	default:
+		return detailedError{reason: ErrAlgorithmMismatch, message: fmt.Sprintf("unsupported key type %T", publicKey)}
+	}
+
+	return detailedError{
+		reason:  ErrAlgorithmMismatch,
+		message: fmt.Sprintf("signing method %s doesn't match key type %s", alg, keyType(publicKey)),
+	}
+}
+
+func curveAlgorithm(curve elliptic.Curve) string {
+	switch curve {
+	case elliptic.P256():
+		return "ES256"
+	case elliptic.P384():
+		return "ES384"
+	case elliptic.P521():
+		return "ES512"
+	}
+
+	return ""
+}
+
+func keyType(publicKey crypto.PublicKey) string {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PublicKey:
+		return "EC " + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+
+	return fmt.Sprintf("%T", publicKey)
+}
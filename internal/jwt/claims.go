@@ -0,0 +1,84 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// validateTimes mirrors RegisteredClaims.Valid, tolerating the configured clock skew on exp, nbf and iat.
+// It returns the claims that only passed thanks to the leeway.
+func (p parser) validateTimes(claims *customClaims, now time.Time) ([]string, error) {
+	vErr := new(jwt.ValidationError)
+	var leewayUsed []string
+
+	if claims.ExpiresAt != nil {
+		exp := claims.ExpiresAt.Time
+		if !now.Before(exp.Add(p.leeway)) {
+			vErr.Inner = fmt.Errorf("%s by %s", jwt.ErrTokenExpired, now.Sub(exp))
+			vErr.Errors |= jwt.ValidationErrorExpired
+		} else if !now.Before(exp) {
+			leewayUsed = append(leewayUsed, "exp")
+		}
+	}
+
+	if claims.IssuedAt != nil {
+		iat := claims.IssuedAt.Time
+		if now.Add(p.leeway).Before(iat) {
+			vErr.Inner = jwt.ErrTokenUsedBeforeIssued
+			vErr.Errors |= jwt.ValidationErrorIssuedAt
+		} else if now.Before(iat) {
+			leewayUsed = append(leewayUsed, "iat")
+		}
+	}
+
+	if claims.NotBefore != nil {
+		nbf := claims.NotBefore.Time
+		if now.Add(p.leeway).Before(nbf) {
+			vErr.Inner = jwt.ErrTokenNotValidYet
+			vErr.Errors |= jwt.ValidationErrorNotValidYet
+		} else if now.Before(nbf) {
+			leewayUsed = append(leewayUsed, "nbf")
+		}
+	}
+
+	if vErr.Errors != 0 {
+		return nil, vErr
+	}
+
+	return leewayUsed, nil
+}
+
+func (c *customClaims) UnmarshalJSON(data []byte) error {
+	// the alias drops this method, so the typed fields are decoded the usual way
+	type typedClaims customClaims
+	if err := json.Unmarshal(data, (*typedClaims)(c)); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(&c.all)
+}
+
+// Claim looks up a claim by dot separated path, e.g. "realm_access.roles"
+func (p *ParsedJWT) Claim(path string) (any, bool) {
+	var current any = p.Claims
+	for _, segment := range strings.Split(path, ".") {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[segment]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, current != nil
+}
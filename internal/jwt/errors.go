@@ -0,0 +1,68 @@
+package jwt
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrAlgorithmNotAllowed = errors.New("signing method not allowed")
+	ErrAlgorithmMismatch   = errors.New("signing method doesn't match the key")
+	ErrMissingKeyID        = errors.New("no kid in jwt")
+	ErrKeyNotFound         = errors.New("couldn't retrieve the signing key")
+	ErrMalformedKey        = errors.New("public key malformed")
+	ErrInvalidIssuer       = errors.New("token has invalid issuer")
+	ErrMissingAudience     = errors.New("token has no audience")
+	ErrInvalidAudience     = errors.New("token has invalid audience")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+)
+
+// detailedError keeps a descriptive message while still matching its sentinel with errors.Is
+type detailedError struct {
+	reason  error
+	message string
+}
+
+func (e detailedError) Error() string {
+	return e.message
+}
+
+func (e detailedError) Unwrap() error {
+	return e.reason
+}
+
+// reasons are checked in order, the first match wins
+var reasons = []struct {
+	err    error
+	reason string
+}{
+	{jwt.ErrTokenExpired, "expired"},
+	{jwt.ErrTokenNotValidYet, "not_valid_yet"},
+	{jwt.ErrTokenUsedBeforeIssued, "used_before_issued"},
+	{ErrAlgorithmNotAllowed, "algorithm_not_allowed"},
+	{ErrAlgorithmMismatch, "algorithm_mismatch"},
+	{ErrMissingKeyID, "missing_kid"},
+	{ErrKeyNotFound, "key_not_found"},
+	{ErrMalformedKey, "malformed_key"},
+	{ErrInvalidIssuer, "invalid_issuer"},
+	{ErrMissingAudience, "missing_audience"},
+	{ErrInvalidAudience, "invalid_audience"},
+	{ErrTokenRevoked, "revoked"},
+	{jwt.ErrTokenSignatureInvalid, "invalid_signature"},
+	{jwt.ErrTokenMalformed, "malformed"},
+}
+
+// Reason returns a short, stable label describing why a token was refused, meant for metrics and logs
+func Reason(err error) string {
+	if err == nil {
+		return "success"
+	}
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+
+	return "invalid"
+}
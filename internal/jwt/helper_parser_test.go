@@ -1,6 +1,7 @@
 package jwt_test
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -52,6 +53,36 @@ func generateValidJWT(signingKey string, ttl time.Duration, sub string, purpose
 	return signedToken, nil
 }
 
+// generateSignedJWT signs claims with any supported method, publicKey is returned PEM encoded
+func generateSignedJWT(method jwt.SigningMethod, signKey crypto.Signer, kid string) (token string, publicKey string, err error) {
+	t := jwt.NewWithClaims(method, buildClaims(time.Second*10, "sub", "purpose", nil))
+	t.Header["kid"] = kid
+	token, err = t.SignedString(signKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	marshalledPublicKey, err := x509.MarshalPKIXPublicKey(signKey.Public())
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: marshalledPublicKey})), nil
+}
+
+// generateJWTWithClaims signs arbitrary claims with the shared RSA key pair
+func generateJWTWithClaims(claims jwt.MapClaims, kid string) (string, error) {
+	signKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(keyPair.PrivateKey))
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	return token.SignedString(signKey)
+}
+
 func getEncodedPrivateKey(keyPair *rsa.PrivateKey) (string, error) {
 	pkcs8Key, err := x509.MarshalPKCS8PrivateKey(keyPair)
 	if err != nil {
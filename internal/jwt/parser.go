@@ -1,54 +1,337 @@
 package jwt
 
 import (
+	"crypto"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+	"github.com/weeb-vip/gateway-proxy/internal/lru"
 	"github.com/weeb-vip/gateway-proxy/internal/poller"
+	"github.com/weeb-vip/gateway-proxy/metrics"
+	"strings"
+	"time"
 )
 
 func (p parser) Parse(token string) (*ParsedJWT, error) {
-	claims := &customClaims{}
-	t, err := jwt.ParseWithClaims(token, claims, p.keyFunc)
+	parsed, err := p.parseCached(token)
+	if err != nil {
+		return nil, err
+	}
+	// revocations apply to cached tokens too, so they are checked on every call
+	if err := p.checkRevocation(parsed); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+func (p parser) parseCached(token string) (*ParsedJWT, error) {
+	if p.tokens == nil {
+		parsed, _, err := p.parse(token)
+		return parsed, err
+	}
+
+	// only verified tokens are cached, keyed by hash so the cache never holds credentials
+	hash := sha256.Sum256([]byte(token))
+	if cached, ok := p.tokens.Get(hash); ok {
+		// the signature was checked against this key, it has to still be served for the token to verify
+		key, err := p.keyManager.FindKeyByID(cached.keyID)
+		if err == nil && key.Body == cached.keyBody {
+			return cached.parsed, nil
+		}
+		p.tokens.Remove(hash)
+	}
+	parsed, key, err := p.parse(token)
 	if err != nil {
 		return nil, err
 	}
+	if !parsed.ExpiresAt.IsZero() {
+		p.tokens.Add(hash, cachedToken{parsed: parsed, keyID: key.ID, keyBody: key.Body}, parsed.ExpiresAt)
+	}
+
+	return parsed, nil
+}
+
+func (p parser) checkRevocation(parsed *ParsedJWT) error {
+	if p.revocation == nil {
+		return nil
+	}
+	revoked, err := p.revocation.IsRevoked(parsed)
+	if err != nil {
+		return fmt.Errorf("revocation check failed: %w", err)
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
+// parse verifies the token, it also returns the key the signature was verified with
+func (p parser) parse(token string) (*ParsedJWT, *keys.Key, error) {
+	claims := &customClaims{}
+	v := &verification{}
+	// time based claims are validated by keyFunc, with leeway
+	jwtParser := jwt.Parser{SkipClaimsValidation: true}
+	t, err := jwtParser.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
+		return p.keyFunc(token, v)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 	if !t.Valid {
-		return nil, errors.New("invalid token")
+		return nil, nil, errors.New("invalid token")
+	}
+
+	if err := p.validateIssuer(claims, v.key); err != nil {
+		return nil, nil, err
+	}
+	audience, err := p.validateAudience(claims, v.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(v.leewayUsed) > 0 {
+		recordLeeway(claims, v.key.ID, v.leewayUsed)
+	}
+
+	parsed := &ParsedJWT{
+		ID:        claims.ID,
+		Subject:   &claims.Subject,
+		Audience:  audience,
+		Audiences: claims.Audience,
+		Issuer:    claims.Issuer,
+		Purpose:   claims.Purpose,
+		Claims:    claims.all,
+	}
+	if claims.ExpiresAt != nil {
+		parsed.ExpiresAt = claims.ExpiresAt.Time
+	}
+	if claims.IssuedAt != nil {
+		parsed.IssuedAt = claims.IssuedAt.Time
 	}
 
-	return &ParsedJWT{
-		Subject:  &claims.Subject,
-		Audience: &claims.Audience[0],
-		Purpose:  claims.Purpose,
-	}, nil
+	return parsed, v.key, nil
 }
 
-func (p parser) keyFunc(token *jwt.Token) (any, error) {
-	if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
-		return nil, fmt.Errorf("expected signing method: %s, got: %s", jwt.SigningMethodRS256.Alg(), token.Method.Alg())
+// keyFunc resolves the verification key, v collects what Parse needs once the signature is verified
+func (p parser) keyFunc(token *jwt.Token, v *verification) (any, error) {
+	alg := token.Method.Alg()
+	if !p.isAllowed(alg) {
+		return nil, detailedError{
+			reason:  ErrAlgorithmNotAllowed,
+			message: fmt.Sprintf("expected signing method: %s, got: %s", strings.Join(p.algorithms, ", "), alg),
+		}
 	}
-	err := token.Claims.Valid()
+	claims := token.Claims.(*customClaims)
+	leewayUsed, err := p.validateTimes(claims, time.Now())
 	if err != nil {
 		return nil, err
 	}
-	keyID := token.Header["kid"]
-	if keyID == nil || keyID.(string) == "" {
-		return nil, errors.New("no kid in jwt")
+	keyID, _ := token.Header["kid"].(string)
+	if keyID == "" {
+		return nil, ErrMissingKeyID
+	}
+	key, err := p.keyManager.FindKeyByID(keyID)
+	if err != nil {
+		return nil, ErrKeyNotFound
 	}
-	key, err := p.keyManager.FindKeyByID(keyID.(string))
+
+	publicKey, err := p.publicKey(key)
 	if err != nil {
-		return nil, errors.New("couldn't retrieve the signing key")
+		return nil, ErrMalformedKey
+	}
+	if key.Algorithm != "" && key.Algorithm != alg {
+		return nil, detailedError{
+			reason:  ErrAlgorithmMismatch,
+			message: fmt.Sprintf("signing method %s doesn't match key algorithm %s", alg, key.Algorithm),
+		}
+	}
+	if err := checkAlgorithmMatchesKey(alg, publicKey); err != nil {
+		return nil, err
+	}
+
+	v.key = key
+	v.leewayUsed = leewayUsed
+
+	return publicKey, nil
+}
+
+// publicKey parses the key body once per kid, a rotated body under the same kid is parsed again
+func (p parser) publicKey(key *keys.Key) (crypto.PublicKey, error) {
+	if cached, ok := p.publicKeys.Get(key.ID); ok && cached.body == key.Body {
+		return cached.key, nil
 	}
 
-	rsaKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(key.Body))
+	publicKey, err := parsePublicKey(key.Body)
 	if err != nil {
-		return nil, errors.New("public key malformed")
+		return nil, err
 	}
+	p.publicKeys.Add(key.ID, cachedPublicKey{body: key.Body, key: publicKey}, time.Time{})
 
-	return rsaKey, nil
+	return publicKey, nil
 }
 
-func NewParser(keyManager poller.KeyManager) Parser {
-	return parser{keyManager: keyManager}
+// validateIssuer prefers the issuer the key source is trusted for over the globally expected issuers
+func (p parser) validateIssuer(claims *customClaims, key *keys.Key) error {
+	if key != nil && key.Issuer != "" {
+		if claims.Issuer != key.Issuer {
+			return fmt.Errorf("%w: %q", ErrInvalidIssuer, claims.Issuer)
+		}
+		return nil
+	}
+	if len(p.issuers) == 0 {
+		return nil
+	}
+	for _, issuer := range p.issuers {
+		if claims.Issuer == issuer {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %q", ErrInvalidIssuer, claims.Issuer)
+}
+
+// validateAudience returns the audience the token was accepted for, the first one when nothing is expected
+func (p parser) validateAudience(claims *customClaims, key *keys.Key) (*string, error) {
+	expected := p.audiences
+	if key != nil && len(key.Audiences) > 0 {
+		expected = key.Audiences
+	}
+
+	if len(expected) == 0 {
+		if len(claims.Audience) == 0 {
+			return nil, nil
+		}
+		return &claims.Audience[0], nil
+	}
+
+	if len(claims.Audience) == 0 {
+		return nil, ErrMissingAudience
+	}
+	for i, audience := range claims.Audience {
+		for _, allowed := range expected {
+			if audience == allowed {
+				return &claims.Audience[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrInvalidAudience, strings.Join(claims.Audience, ", "))
+}
+
+// recordLeeway reports tokens that are only accepted thanks to the leeway, a steady rate points to a drifting host
+func recordLeeway(claims *customClaims, keyID string, leewayUsed []string) {
+	appMetrics := metrics.GetAppMetrics()
+	for _, claim := range leewayUsed {
+		appMetrics.JWTLeewayMetric(claim, claims.Issuer)
+	}
+
+	log := logger.Get()
+	log.Warn().
+		Strs("claims", leewayUsed).
+		Str("issuer", claims.Issuer).
+		Str("kid", keyID).
+		Msg("Token accepted within clock skew leeway")
+}
+
+func (p parser) isAllowed(alg string) bool {
+	for _, allowed := range p.algorithms {
+		if allowed == alg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithAllowedAlgorithms restricts the signing algorithms tokens may use, an empty list keeps the default
+func WithAllowedAlgorithms(algorithms ...string) Option {
+	return func(p *parser) {
+		if len(algorithms) > 0 {
+			p.algorithms = algorithms
+		}
+	}
+}
+
+// WithIssuers sets the accepted issuers for keys whose source doesn't pin one
+func WithIssuers(issuers ...string) Option {
+	return func(p *parser) {
+		p.issuers = issuers
+	}
+}
+
+// WithAudiences sets the accepted audiences for keys whose source doesn't pin any
+func WithAudiences(audiences ...string) Option {
+	return func(p *parser) {
+		p.audiences = audiences
+	}
+}
+
+// WithLeeway tolerates clock skew between the token issuer and the gateway on exp, nbf and iat
+func WithLeeway(leeway time.Duration) Option {
+	return func(p *parser) {
+		p.leeway = leeway
+	}
+}
+
+// WithTokenCache keeps up to size verified tokens until they expire, zero disables the cache
+func WithTokenCache(size int) Option {
+	return func(p *parser) {
+		if size > 0 {
+			p.tokens = lru.New[[sha256.Size]byte, cachedToken](size)
+		}
+	}
+}
+
+// WithRevocationChecker refuses verified tokens the checker reports as revoked
+func WithRevocationChecker(checker RevocationChecker) Option {
+	return func(p *parser) {
+		p.revocation = checker
+	}
+}
+
+func NewParser(keyManager poller.KeyManager, opts ...Option) Parser {
+	p := parser{
+		keyManager: keyManager,
+		algorithms: DefaultAlgorithms,
+		publicKeys: lru.New[string, cachedPublicKey](publicKeyCacheSize),
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	if p.tokens != nil {
+		if events, _ := keyManager.Watch(); events != nil {
+			// the watch ends with the key manager
+			go p.purgeTokens(events)
+		}
+	}
+
+	return p
+}
+
+// purgeTokens drops cached tokens verified with a key that changed or left the key set,
+// they are verified again on their next use
+func (p parser) purgeTokens(events <-chan keys.Event) {
+	for event := range events {
+		if len(event.Changed)+len(event.Removed) == 0 {
+			continue
+		}
+		stale := make(map[string]bool, len(event.Changed)+len(event.Removed))
+		for _, id := range event.Changed {
+			stale[id] = true
+		}
+		for _, id := range event.Removed {
+			stale[id] = true
+		}
+		purged := p.tokens.RemoveFunc(func(_ [sha256.Size]byte, cached cachedToken) bool {
+			return stale[cached.keyID]
+		})
+		if purged > 0 {
+			log := logger.Get()
+			log.Info().Int("tokens", purged).Strs("changed", event.Changed).Strs("removed", event.Removed).Msg("Dropped cached tokens of changed keys")
+		}
+	}
 }
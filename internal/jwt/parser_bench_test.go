@@ -0,0 +1,36 @@
+package jwt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+)
+
+func benchmarkParse(b *testing.B, opts ...jwt.Option) {
+	keyID := "my-kid"
+	token, err := generateTestJWTCredentials(time.Hour, "sub", "purpose", &keyID, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	parser := jwt.NewParser(signingKeys{k: keyPair.PublicKey, kid: keyID}, opts...)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := parser.Parse(token); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkParse(b *testing.B) {
+	b.Run("without token cache", func(b *testing.B) {
+		benchmarkParse(b)
+	})
+	b.Run("with token cache", func(b *testing.B) {
+		benchmarkParse(b, jwt.WithTokenCache(1000))
+	})
+}
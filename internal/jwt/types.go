@@ -1,25 +1,79 @@
 package jwt
 
 import (
+	"crypto"
+	"crypto/sha256"
+	"time"
+
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"github.com/weeb-vip/gateway-proxy/internal/lru"
 	"github.com/weeb-vip/gateway-proxy/internal/poller"
 )
 
 type customClaims struct {
 	jwt.RegisteredClaims
 	Purpose *string `json:"purpose"`
+	// all keeps every claim of the token, including the ones without a typed field
+	all map[string]any
 }
 
 type ParsedJWT struct {
-	Subject  *string
-	Audience *string
-	Purpose  *string
+	// ID is the jti claim, empty when the token has none
+	ID      string
+	Subject *string
+	// Audience is the audience the token was accepted for, nil when the token has none
+	Audience  *string
+	Audiences []string
+	Issuer    string
+	Purpose   *string
+	// ExpiresAt is zero when the token has no exp claim
+	ExpiresAt time.Time
+	// IssuedAt is zero when the token has no iat claim
+	IssuedAt time.Time
+	// Claims is the full claim set, numbers are kept as json.Number
+	Claims map[string]any
 }
 
 type Parser interface {
 	Parse(token string) (*ParsedJWT, error)
 }
 
+// RevocationChecker reports whether a verified token has been revoked since it was issued
+type RevocationChecker interface {
+	IsRevoked(token *ParsedJWT) (bool, error)
+}
+
 type parser struct {
 	keyManager poller.KeyManager
+	algorithms []string
+	issuers    []string
+	audiences  []string
+	leeway     time.Duration
+	tokens     *lru.Cache[[sha256.Size]byte, cachedToken]
+	publicKeys *lru.Cache[string, cachedPublicKey]
+	revocation RevocationChecker
+}
+
+const publicKeyCacheSize = 256
+
+// cachedToken remembers the key a token was verified with, the token stops verifying with it
+type cachedToken struct {
+	parsed  *ParsedJWT
+	keyID   string
+	keyBody string
 }
+
+type cachedPublicKey struct {
+	body string
+	key  crypto.PublicKey
+}
+
+// verification carries the outcome of keyFunc back to Parse
+type verification struct {
+	key        *keys.Key
+	leewayUsed []string
+}
+
+// Option configures the parser
+type Option func(*parser)
@@ -1,8 +1,12 @@
 package keys
 
 import (
-	"errors"
-	"github.com/machinebox/graphql"
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
 )
 
 const FetchKeysDocument = `
@@ -14,19 +18,64 @@ query FetchAllKeys{
 }
 `
 
-func (r keyFetcher) FetchKeys() ([]Key, error) {
-	response, err := run[GraphQLResponse](graphql.NewClient(r.graphqlEndpoint), graphql.NewRequest(FetchKeysDocument))
+// defaultFetchTimeout bounds a whole fetch from the key service, response body included
+const defaultFetchTimeout = 10 * time.Second
+
+// traceHeaders carries the trace of the fetch to the key service
+var traceHeaders = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+type FetcherOption func(f *keyFetcher)
+
+func (r keyFetcher) FetchKeys(ctx context.Context) ([]Key, error) {
+	headers := http.Header{}
+	if r.token != "" {
+		headers.Set("Authorization", "Bearer "+r.token)
+	}
+	response, err := run[GraphQLResponse](ctx, r.client, r.graphqlEndpoint, FetchKeysDocument, headers)
 	if err != nil {
 		return nil, err
 	}
 
-	if response == nil {
-		return nil, errors.New("nil response returned")
+	return response.Keys, nil
+}
+
+// WithTimeout bounds every fetch, zero keeps the default
+func WithTimeout(timeout time.Duration) FetcherOption {
+	return func(f *keyFetcher) {
+		if timeout > 0 {
+			f.client.Timeout = timeout
+		}
+	}
+}
+
+// WithServiceToken authenticates the gateway to the key service with a bearer token
+func WithServiceToken(token string) FetcherOption {
+	return func(f *keyFetcher) {
+		f.token = token
 	}
+}
 
-	return response.Keys, nil
+// WithTLSConfig sets the TLS configuration for the key service, with a client certificate for mTLS
+func WithTLSConfig(tlsConfig *tls.Config) FetcherOption {
+	return func(f *keyFetcher) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		f.client.Transport = transport
+	}
 }
 
-func NewFetcher(endpoint string) Fetcher {
-	return keyFetcher{graphqlEndpoint: endpoint}
+// NewFetcher loads keys from the key-management GraphQL service, the HTTP client is shared by every fetch
+func NewFetcher(endpoint string, opts ...FetcherOption) Fetcher {
+	f := &keyFetcher{
+		graphqlEndpoint: endpoint,
+		client:          &http.Client{Timeout: defaultFetchTimeout},
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return *f
 }
@@ -3,6 +3,7 @@
 package keys_test
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/weeb-vip/gateway-proxy/internal/keys"
 	"testing"
@@ -13,7 +14,7 @@ import (
 
 func TestNewKeyFetcher(t *testing.T) {
 	fetcher := keys.NewFetcher("http://localhost:5000/graphql")
-	k, err := fetcher.FetchKeys()
+	k, err := fetcher.FetchKeys(context.Background())
 	assert.NoError(t, err)
 	assert.NotEmpty(t, k)
 }
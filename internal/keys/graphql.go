@@ -1,17 +1,96 @@
 package keys
 
 import (
+	"bytes"
 	"context"
-	"github.com/machinebox/graphql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
 )
 
-func run[T any](client *graphql.Client, request *graphql.Request) (*T, error) {
-	response := new(T)
+// maxResponseSize bounds what is read from the key service
+const maxResponseSize = 1 << 20
+
+// GraphQLError is an entry of the errors array of a GraphQL response
+type GraphQLError struct {
+	Message    string         `json:"message"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+func (e GraphQLError) Error() string {
+	return e.Message
+}
+
+// Code is the extensions.code of the error, empty when the server didn't set one
+func (e GraphQLError) Code() string {
+	code, _ := e.Extensions["code"].(string)
+
+	return code
+}
+
+// GraphQLErrors is returned when a response carries errors, match it with errors.As
+type GraphQLErrors []GraphQLError
+
+func (e GraphQLErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+
+	return "graphql: " + strings.Join(messages, "; ")
+}
+
+type graphqlRequest struct {
+	Query string `json:"query"`
+}
+
+type graphqlResponse[T any] struct {
+	Data   *T            `json:"data"`
+	Errors GraphQLErrors `json:"errors"`
+}
 
-	err := client.Run(context.Background(), request, &response)
+func run[T any](ctx context.Context, client *http.Client, endpoint string, document string, headers http.Header) (*T, error) {
+	body, err := json.Marshal(graphqlRequest{Query: document})
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
+	for name, values := range headers {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	traceHeaders.Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var result graphqlResponse[T]
+	decodeErr := json.NewDecoder(io.LimitReader(response.Body, maxResponseSize)).Decode(&result)
+	// servers may answer errors with a non 2xx status, they say more than the status does
+	if decodeErr == nil && len(result.Errors) > 0 {
+		return nil, result.Errors
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("key service responded with status %d", response.StatusCode)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("invalid GraphQL response: %w", decodeErr)
+	}
+	if result.Data == nil {
+		return nil, fmt.Errorf("GraphQL response has no data")
+	}
 
-	return response, nil
+	return result.Data, nil
 }
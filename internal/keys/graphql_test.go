@@ -0,0 +1,118 @@
+package keys_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func keyService(t *testing.T, status int, body string, requests chan<- *http.Request) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Query string `json:"query"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		assert.Equal(t, keys.FetchKeysDocument, payload.Query)
+		if requests != nil {
+			requests <- r
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFetcher(t *testing.T) {
+	t.Run("returns the keys of the key service", func(t *testing.T) {
+		server := keyService(t, http.StatusOK, `{"data":{"keys":[{"id":"id1","body":"body1"},{"id":"id2","body":"body2"}]}}`, nil)
+
+		result, err := keys.NewFetcher(server.URL).FetchKeys(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []keys.Key{{ID: "id1", Body: "body1"}, {ID: "id2", Body: "body2"}}, result)
+	})
+	t.Run("authenticates with the service token and carries the trace", func(t *testing.T) {
+		requests := make(chan *http.Request, 1)
+		server := keyService(t, http.StatusOK, `{"data":{"keys":[]}}`, requests)
+		spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1},
+			SpanID:     trace.SpanID{2},
+			TraceFlags: trace.FlagsSampled,
+		})
+		ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+
+		_, err := keys.NewFetcher(server.URL, keys.WithServiceToken("secret")).FetchKeys(ctx)
+		require.NoError(t, err)
+
+		request := <-requests
+		assert.Equal(t, "Bearer secret", request.Header.Get("Authorization"))
+		assert.Equal(t, "00-01000000000000000000000000000000-0200000000000000-01", request.Header.Get("traceparent"))
+	})
+	t.Run("sends no authorization without a service token", func(t *testing.T) {
+		requests := make(chan *http.Request, 1)
+		server := keyService(t, http.StatusOK, `{"data":{"keys":[]}}`, requests)
+
+		_, err := keys.NewFetcher(server.URL).FetchKeys(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, (<-requests).Header.Get("Authorization"))
+	})
+	t.Run("returns the GraphQL errors as typed errors", func(t *testing.T) {
+		server := keyService(t, http.StatusOK, `{"data":null,"errors":[{"message":"not allowed","path":["keys"],"extensions":{"code":"FORBIDDEN"}}]}`, nil)
+
+		_, err := keys.NewFetcher(server.URL).FetchKeys(context.Background())
+
+		var graphqlErrors keys.GraphQLErrors
+		require.True(t, errors.As(err, &graphqlErrors))
+		require.Len(t, graphqlErrors, 1)
+		assert.Equal(t, "not allowed", graphqlErrors[0].Message)
+		assert.Equal(t, "FORBIDDEN", graphqlErrors[0].Code())
+		assert.Equal(t, []any{"keys"}, graphqlErrors[0].Path)
+		assert.EqualError(t, err, "graphql: not allowed")
+	})
+	t.Run("prefers the GraphQL errors to the status of the response", func(t *testing.T) {
+		server := keyService(t, http.StatusUnauthorized, `{"errors":[{"message":"invalid token","extensions":{"code":"UNAUTHENTICATED"}}]}`, nil)
+
+		_, err := keys.NewFetcher(server.URL).FetchKeys(context.Background())
+
+		var graphqlErrors keys.GraphQLErrors
+		require.True(t, errors.As(err, &graphqlErrors))
+		assert.Equal(t, "UNAUTHENTICATED", graphqlErrors[0].Code())
+	})
+	t.Run("returns an error for a failing status without GraphQL errors", func(t *testing.T) {
+		server := keyService(t, http.StatusBadGateway, `bad gateway`, nil)
+
+		_, err := keys.NewFetcher(server.URL).FetchKeys(context.Background())
+		assert.ErrorContains(t, err, "status 502")
+	})
+	t.Run("gives up when the key service is slower than the timeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}))
+		defer server.Close()
+
+		_, err := keys.NewFetcher(server.URL, keys.WithTimeout(20*time.Millisecond)).FetchKeys(context.Background())
+		assert.Error(t, err)
+	})
+	t.Run("stops when the context is cancelled", func(t *testing.T) {
+		server := keyService(t, http.StatusOK, `{"data":{"keys":[]}}`, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := keys.NewFetcher(server.URL).FetchKeys(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
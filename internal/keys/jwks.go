@@ -0,0 +1,250 @@
+package keys
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const jwksRequestTimeout = 10 * time.Second
+
+// JSONWebKeySet is a JWKS document as described in RFC 7517
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JSONWebKey holds the public members of a JWK we know how to convert
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+func (r jwksFetcher) FetchKeys(ctx context.Context) ([]Key, error) {
+	document, err := r.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set JSONWebKeySet
+	if err := json.Unmarshal(document, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS document: %w", err)
+	}
+
+	log := logger.Get()
+	result := make([]Key, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.KeyID == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.toKey()
+		if err != nil {
+			log.Warn().Err(err).Str("kid", jwk.KeyID).Str("source", r.source).Msg("Skipping unusable JWK")
+			continue
+		}
+		result = append(result, *key)
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("no usable signing keys in JWKS")
+	}
+
+	return result, nil
+}
+
+func (r jwksFetcher) load(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(r.source, "http://") && !strings.HasPrefix(r.source, "https://") {
+		return os.ReadFile(strings.TrimPrefix(r.source, "file://"))
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, r.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	traceHeaders.Inject(ctx, propagation.HeaderCarrier(request.Header))
+	response, err := r.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching JWKS: %d", response.StatusCode)
+	}
+
+	// one byte over the cap tells a cut document from a complete one
+	document, err := io.ReadAll(io.LimitReader(response.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(document) > maxResponseSize {
+		return nil, fmt.Errorf("JWKS document exceeds %d bytes", maxResponseSize)
+	}
+
+	return document, nil
+}
+
+func (k JSONWebKey) toKey() (*Key, error) {
+	var publicKey crypto.PublicKey
+	var err error
+	switch k.KeyType {
+	case "RSA":
+		publicKey, err = k.rsaPublicKey()
+	case "EC":
+		publicKey, err = k.ecdsaPublicKey()
+	case "OKP":
+		publicKey, err = k.ed25519PublicKey()
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.KeyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	marshalled, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		ID:        k.KeyID,
+		Body:      string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: marshalled})),
+		Algorithm: k.Algorithm,
+	}, nil
+}
+
+func (k JSONWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent out of range")
+	}
+
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k JSONWebKey) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Curve {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve: %s", k.Curve)
+	}
+
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate: %w", err)
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y coordinate: %w", err)
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on curve")
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func (k JSONWebKey) ed25519PublicKey() (ed25519.PublicKey, error) {
+	if k.Curve != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve: %s", k.Curve)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.X, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate: %w", err)
+	}
+	if len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key size")
+	}
+
+	return ed25519.PublicKey(x), nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	if value == "" {
+		return nil, errors.New("missing value")
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+// NewJSONWebKey encodes a public key as a JWK, the inverse of what the fetcher understands
+func NewJSONWebKey(keyID string, algorithm string, publicKey crypto.PublicKey) (JSONWebKey, error) {
+	jwk := JSONWebKey{KeyID: keyID, Use: "sig", Algorithm: algorithm}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = key.Curve.Params().Name
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.X = base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return JSONWebKey{}, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+
+	return jwk, nil
+}
+
+// NewJWKSFetcher loads keys from a JWKS document, source is either an http(s) URL or a local file path.
+// The client options of graphql fetchers apply, a service token is never sent
+func NewJWKSFetcher(source string, opts ...FetcherOption) Fetcher {
+	f := &keyFetcher{client: &http.Client{Timeout: jwksRequestTimeout}}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return jwksFetcher{
+		source: source,
+		client: f.client,
+	}
+}
@@ -0,0 +1,190 @@
+package keys_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+)
+
+func encodeBigInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   encodeBigInt(key.N),
+		"e":   encodeBigInt(big.NewInt(int64(key.E))),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"alg": "ES256",
+		"crv": "P-256",
+		"x":   encodeBigInt(key.X),
+		"y":   encodeBigInt(key.Y),
+	}
+}
+
+func parsePEM(t *testing.T, body string) any {
+	block, _ := pem.Decode([]byte(body))
+	require.NotNil(t, block)
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	require.NoError(t, err)
+
+	return publicKey
+}
+
+func TestJWKSFetcher(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	document, _ := json.Marshal(map[string]any{
+		"keys": []map[string]string{
+			rsaJWK("rsa-key", &rsaKey.PublicKey),
+			ecJWK("ec-key", &ecKey.PublicKey),
+			{"kty": "OKP", "kid": "ed-key", "alg": "EdDSA", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(edKey)},
+			{"kty": "RSA", "kid": "encryption-key", "use": "enc", "n": "AQAB", "e": "AQAB"},
+			{"kty": "oct", "kid": "symmetric-key", "k": "c2VjcmV0"},
+		},
+	})
+
+	t.Run("converts RSA, EC and OKP keys from a URL and skips the rest", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(document)
+		}))
+		defer server.Close()
+
+		result, err := keys.NewJWKSFetcher(server.URL).FetchKeys(context.Background())
+
+		require.NoError(t, err)
+		require.Len(t, result, 3)
+		assert.Equal(t, "rsa-key", result[0].ID)
+		assert.Equal(t, "RS256", result[0].Algorithm)
+		assert.True(t, rsaKey.PublicKey.Equal(parsePEM(t, result[0].Body)))
+		assert.Equal(t, "ec-key", result[1].ID)
+		assert.Equal(t, "ES256", result[1].Algorithm)
+		assert.True(t, ecKey.PublicKey.Equal(parsePEM(t, result[1].Body)))
+		assert.Equal(t, "ed-key", result[2].ID)
+		assert.True(t, edKey.Equal(parsePEM(t, result[2].Body)))
+	})
+	t.Run("loads a JWKS document from a local file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		require.NoError(t, os.WriteFile(path, document, 0o600))
+
+		result, err := keys.NewJWKSFetcher("file://" + path).FetchKeys(context.Background())
+
+		require.NoError(t, err)
+		assert.Len(t, result, 3)
+	})
+	t.Run("returns an error when the endpoint fails", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		result, err := keys.NewJWKSFetcher(server.URL).FetchKeys(context.Background())
+
+		assert.Error(t, err)
+		assert.Nil(t, result)
+	})
+	t.Run("refuses documents over the size limit", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"keys":[],"padding":"` + strings.Repeat("x", 1<<20) + `"}`))
+		}))
+		defer server.Close()
+
+		result, err := keys.NewJWKSFetcher(server.URL).FetchKeys(context.Background())
+
+		assert.ErrorContains(t, err, "exceeds")
+		assert.Nil(t, result)
+	})
+	t.Run("uses the configured TLS settings without sending the service token", func(t *testing.T) {
+		jwk, err := keys.NewJSONWebKey("ed", "", ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+		require.NoError(t, err)
+		var authorization string
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			_ = json.NewEncoder(w).Encode(keys.JSONWebKeySet{Keys: []keys.JSONWebKey{jwk}})
+		}))
+		defer server.Close()
+		roots := x509.NewCertPool()
+		roots.AddCert(server.Certificate())
+
+		_, err = keys.NewJWKSFetcher(server.URL).FetchKeys(context.Background())
+		assert.Error(t, err)
+
+		result, err := keys.NewJWKSFetcher(server.URL,
+			keys.WithTLSConfig(&tls.Config{RootCAs: roots}),
+			keys.WithServiceToken("service-token"),
+		).FetchKeys(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, result, 1)
+		assert.Empty(t, authorization)
+	})
+	t.Run("returns an error when no key is usable", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"keys":[{"kty":"EC","kid":"k","crv":"P-256","x":"AQ","y":"AQ"}]}`), 0o600))
+
+		result, err := keys.NewJWKSFetcher(path).FetchKeys(context.Background())
+
+		assert.EqualError(t, err, "no usable signing keys in JWKS")
+		assert.Nil(t, result)
+	})
+}
+
+func TestNewJSONWebKey(t *testing.T) {
+	t.Run("encodes keys the JWKS fetcher can read back", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		edKey, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		set := keys.JSONWebKeySet{}
+		for kid, publicKey := range map[string]any{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey, "ed": edKey} {
+			jwk, err := keys.NewJSONWebKey(kid, "", publicKey)
+			require.NoError(t, err)
+			set.Keys = append(set.Keys, jwk)
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(set)
+		}))
+		defer server.Close()
+
+		result, err := keys.NewJWKSFetcher(server.URL).FetchKeys(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, result, 3)
+	})
+	t.Run("returns an error for unsupported keys", func(t *testing.T) {
+		_, err := keys.NewJSONWebKey("kid", "", "not a key")
+		assert.Error(t, err)
+	})
+}
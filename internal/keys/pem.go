@@ -0,0 +1,47 @@
+package keys
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func (r pemFileFetcher) FetchKeys(context.Context) ([]Key, error) {
+	result := make([]Key, 0, len(r.paths))
+	for _, path := range r.paths {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(body)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM block found in %s", path)
+		}
+
+		key := Key{
+			ID:   strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+			Body: string(body),
+		}
+		// a certificate bounds when its key may be used
+		if block.Type == "CERTIFICATE" {
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse certificate in %s: %w", path, err)
+			}
+			key.NotBefore = certificate.NotBefore
+			key.ExpiresAt = certificate.NotAfter
+		}
+		result = append(result, key)
+	}
+
+	return result, nil
+}
+
+// NewPEMFileFetcher loads static public keys from PEM files, the file name without extension is used as kid
+func NewPEMFileFetcher(paths ...string) Fetcher {
+	return pemFileFetcher{paths: paths}
+}
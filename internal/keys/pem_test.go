@@ -0,0 +1,77 @@
+package keys_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+)
+
+const testPEM = `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEEVs/o5+uQbTjL3chynL4wXgUg2R9
+q9UU8I5mEovUf86QZ7kOBIjJwqnzD1omageEHWwHdBO6B+dFabmdT9POxg==
+-----END PUBLIC KEY-----
+`
+
+func TestPEMFileFetcher(t *testing.T) {
+	t.Run("uses the file name as kid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "static-key.pem")
+		require.NoError(t, os.WriteFile(path, []byte(testPEM), 0o600))
+
+		result, err := keys.NewPEMFileFetcher(path).FetchKeys(context.Background())
+
+		require.NoError(t, err)
+		require.Len(t, result, 1)
+		assert.Equal(t, "static-key", result[0].ID)
+		assert.Equal(t, testPEM, result[0].Body)
+	})
+	t.Run("takes the validity of certificates", func(t *testing.T) {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		notBefore := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+		notAfter := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "signing"},
+			NotBefore:    notBefore,
+			NotAfter:     notAfter,
+		}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "signing"}}, &privateKey.PublicKey, privateKey)
+		require.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "signing.pem")
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+		result, err := keys.NewPEMFileFetcher(path).FetchKeys(context.Background())
+
+		require.NoError(t, err)
+		require.Len(t, result, 1)
+		assert.Equal(t, notBefore, result[0].NotBefore)
+		assert.Equal(t, notAfter, result[0].ExpiresAt)
+	})
+	t.Run("returns an error when a file isn't PEM encoded", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "broken.pem")
+		require.NoError(t, os.WriteFile(path, []byte("not a key"), 0o600))
+
+		result, err := keys.NewPEMFileFetcher(path).FetchKeys(context.Background())
+
+		assert.Error(t, err)
+		assert.Nil(t, result)
+	})
+	t.Run("returns an error when a file is missing", func(t *testing.T) {
+		result, err := keys.NewPEMFileFetcher(filepath.Join(t.TempDir(), "missing.pem")).FetchKeys(context.Background())
+
+		assert.Error(t, err)
+		assert.Nil(t, result)
+	})
+}
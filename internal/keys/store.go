@@ -0,0 +1,201 @@
+package keys
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// watchBuffer is how many events a watcher may fall behind before it misses some
+const watchBuffer = 16
+
+// Store indexes keys by kid. Every change publishes a new immutable version, so lookups never wait for a writer.
+// Keys that disappear from the source are retired rather than dropped, tokens they signed keep verifying
+// until the retirement window is over
+type Store struct {
+	current    atomic.Pointer[storeVersion]
+	retirement time.Duration
+	now        func() time.Time
+
+	mu       sync.Mutex
+	watchers map[chan Event]struct{}
+}
+
+type storeVersion struct {
+	version uint64
+	keys    map[string]StoredKey
+}
+
+// StoredKey is a key with what the store knows about its lifecycle
+type StoredKey struct {
+	Key
+	AddedAt time.Time
+	// RetiredAt is when the key disappeared from its source, zero while it is current
+	RetiredAt time.Time
+}
+
+// Event describes what changed between two versions of the store, by kid
+type Event struct {
+	Version uint64
+	Added   []string
+	Changed []string
+	Retired []string
+	Removed []string
+}
+
+type StoreOption func(s *Store)
+
+// Replace makes keys the current set. Keys missing from it are retired, retired keys past the window are removed
+func (s *Store) Replace(keys []Key) Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	previous := s.current.Load()
+	next := &storeVersion{version: previous.version, keys: make(map[string]StoredKey, len(keys))}
+	var event Event
+	for _, key := range keys {
+		stored, ok := previous.keys[key.ID]
+		switch {
+		case !ok || !stored.RetiredAt.IsZero():
+			event.Added = append(event.Added, key.ID)
+			stored = StoredKey{Key: key, AddedAt: now}
+		case !sameKey(stored.Key, key):
+			event.Changed = append(event.Changed, key.ID)
+			stored = StoredKey{Key: key, AddedAt: now}
+		}
+		next.keys[key.ID] = stored
+	}
+	for id, stored := range previous.keys {
+		if _, ok := next.keys[id]; ok {
+			continue
+		}
+		if stored.RetiredAt.IsZero() {
+			stored.RetiredAt = now
+			event.Retired = append(event.Retired, id)
+		}
+		if s.expired(stored, now) {
+			event.Removed = append(event.Removed, id)
+			continue
+		}
+		next.keys[id] = stored
+	}
+
+	if len(event.Added)+len(event.Changed)+len(event.Retired)+len(event.Removed) == 0 {
+		event.Version = previous.version
+		return event
+	}
+	next.version++
+	event.Version = next.version
+	for _, ids := range [][]string{event.Added, event.Changed, event.Retired, event.Removed} {
+		sort.Strings(ids)
+	}
+	s.current.Store(next)
+	s.notify(event)
+
+	return event
+}
+
+// Get returns the key for id, unless it is outside its validity or retired for longer than the window
+func (s *Store) Get(id string) (*Key, bool) {
+	stored, ok := s.current.Load().keys[id]
+	if !ok {
+		return nil, false
+	}
+	now := s.now()
+	if s.expired(stored, now) || !stored.valid(now) {
+		return nil, false
+	}
+
+	return &stored.Key, true
+}
+
+// Keys returns the current keys, sorted by kid, retired ones aren't included
+func (s *Store) Keys() []StoredKey {
+	version := s.current.Load()
+	result := make([]StoredKey, 0, len(version.keys))
+	for _, stored := range version.keys {
+		if stored.RetiredAt.IsZero() {
+			result = append(result, stored)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+}
+
+func (s *Store) Version() uint64 {
+	return s.current.Load().version
+}
+
+// Watch returns a channel receiving an event for every change and a function ending the watch.
+// A watcher that falls behind by more than a few events misses some, Version tells how many
+func (s *Store) Watch() (<-chan Event, func()) {
+	events := make(chan Event, watchBuffer)
+	s.mu.Lock()
+	s.watchers[events] = struct{}{}
+	s.mu.Unlock()
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.watchers, events)
+			s.mu.Unlock()
+			close(events)
+		})
+	}
+}
+
+// notify has to be called with mu held
+func (s *Store) notify(event Event) {
+	for events := range s.watchers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+func (s *Store) expired(stored StoredKey, now time.Time) bool {
+	return !stored.RetiredAt.IsZero() && now.Sub(stored.RetiredAt) >= s.retirement
+}
+
+func (k StoredKey) valid(now time.Time) bool {
+	if !k.NotBefore.IsZero() && now.Before(k.NotBefore) {
+		return false
+	}
+
+	return k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt)
+}
+
+func sameKey(a Key, b Key) bool {
+	return a.Body == b.Body &&
+		a.Algorithm == b.Algorithm &&
+		a.Source == b.Source &&
+		a.Issuer == b.Issuer &&
+		a.NotBefore.Equal(b.NotBefore) &&
+		a.ExpiresAt.Equal(b.ExpiresAt) &&
+		slices.Equal(a.Audiences, b.Audiences)
+}
+
+// WithRetirement keeps keys that disappeared from the source for the window, zero drops them right away
+func WithRetirement(window time.Duration) StoreOption {
+	return func(s *Store) {
+		s.retirement = window
+	}
+}
+
+func NewStore(opts ...StoreOption) *Store {
+	s := &Store{now: time.Now, watchers: make(map[chan Event]struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.current.Store(&storeVersion{keys: map[string]StoredKey{}})
+
+	return s
+}
@@ -0,0 +1,95 @@
+package keys_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+)
+
+func TestStore(t *testing.T) {
+	t.Run("indexes keys by kid", func(t *testing.T) {
+		store := keys.NewStore()
+
+		event := store.Replace([]keys.Key{{ID: "id1", Body: "body1"}, {ID: "id2", Body: "body2", Source: "idp", Algorithm: "ES256"}})
+
+		assert.Equal(t, keys.Event{Version: 1, Added: []string{"id1", "id2"}}, event)
+		key, ok := store.Get("id2")
+		require.True(t, ok)
+		assert.Equal(t, "body2", key.Body)
+		assert.Equal(t, "idp", key.Source)
+		assert.Equal(t, "ES256", key.Algorithm)
+		_, ok = store.Get("id3")
+		assert.False(t, ok)
+	})
+	t.Run("only publishes a new version when something changed", func(t *testing.T) {
+		store := keys.NewStore()
+		store.Replace([]keys.Key{{ID: "id1", Body: "body1"}})
+
+		assert.Equal(t, keys.Event{Version: 1}, store.Replace([]keys.Key{{ID: "id1", Body: "body1"}}))
+		assert.Equal(t, keys.Event{Version: 2, Changed: []string{"id1"}}, store.Replace([]keys.Key{{ID: "id1", Body: "rotated"}}))
+		assert.Equal(t, uint64(2), store.Version())
+		key, _ := store.Get("id1")
+		assert.Equal(t, "rotated", key.Body)
+	})
+	t.Run("keeps keys that disappeared until the retirement window is over", func(t *testing.T) {
+		store := keys.NewStore(keys.WithRetirement(50 * time.Millisecond))
+		store.Replace([]keys.Key{{ID: "old", Body: "old"}})
+
+		event := store.Replace([]keys.Key{{ID: "new", Body: "new"}})
+
+		assert.Equal(t, keys.Event{Version: 2, Added: []string{"new"}, Retired: []string{"old"}}, event)
+		_, ok := store.Get("old")
+		assert.True(t, ok, "tokens in flight still verify")
+		require.Len(t, store.Keys(), 1)
+		assert.Equal(t, "new", store.Keys()[0].ID)
+
+		time.Sleep(60 * time.Millisecond)
+		_, ok = store.Get("old")
+		assert.False(t, ok)
+		assert.Equal(t, keys.Event{Version: 3, Removed: []string{"old"}}, store.Replace([]keys.Key{{ID: "new", Body: "new"}}))
+	})
+	t.Run("drops keys right away without a retirement window", func(t *testing.T) {
+		store := keys.NewStore()
+		store.Replace([]keys.Key{{ID: "old", Body: "old"}})
+
+		event := store.Replace(nil)
+
+		assert.Equal(t, keys.Event{Version: 2, Retired: []string{"old"}, Removed: []string{"old"}}, event)
+		_, ok := store.Get("old")
+		assert.False(t, ok)
+	})
+	t.Run("refuses keys outside their validity", func(t *testing.T) {
+		store := keys.NewStore()
+		store.Replace([]keys.Key{
+			{ID: "current", Body: "current", NotBefore: time.Now().Add(-time.Hour), ExpiresAt: time.Now().Add(time.Hour)},
+			{ID: "upcoming", Body: "upcoming", NotBefore: time.Now().Add(time.Hour)},
+			{ID: "expired", Body: "expired", ExpiresAt: time.Now().Add(-time.Minute)},
+		})
+
+		_, ok := store.Get("current")
+		assert.True(t, ok)
+		_, ok = store.Get("upcoming")
+		assert.False(t, ok)
+		_, ok = store.Get("expired")
+		assert.False(t, ok)
+	})
+	t.Run("tells watchers about every change until they stop watching", func(t *testing.T) {
+		store := keys.NewStore()
+		events, stop := store.Watch()
+
+		store.Replace([]keys.Key{{ID: "id1", Body: "body1"}})
+		store.Replace([]keys.Key{{ID: "id1", Body: "body1"}})
+		store.Replace([]keys.Key{{ID: "id2", Body: "body2"}})
+
+		assert.Equal(t, keys.Event{Version: 1, Added: []string{"id1"}}, <-events)
+		assert.Equal(t, keys.Event{Version: 2, Added: []string{"id2"}, Retired: []string{"id1"}, Removed: []string{"id1"}}, <-events)
+		stop()
+		stop()
+		store.Replace(nil)
+		_, open := <-events
+		assert.False(t, open)
+	})
+}
@@ -1,11 +1,28 @@
 package keys
 
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
 type Fetcher interface {
-	FetchKeys() ([]Key, error)
+	FetchKeys(ctx context.Context) ([]Key, error)
 }
 
 type keyFetcher struct {
 	graphqlEndpoint string
+	client          *http.Client
+	token           string
+}
+
+type jwksFetcher struct {
+	source string
+	client *http.Client
+}
+
+type pemFileFetcher struct {
+	paths []string
 }
 
 type GraphQLResponse struct {
@@ -15,4 +32,15 @@ type GraphQLResponse struct {
 type Key struct {
 	ID   string `json:"id"`
 	Body string `json:"body"`
+	// Algorithm is the JWS algorithm the key is meant for, empty when the source doesn't say
+	Algorithm string `json:"algorithm,omitempty"`
+	// Source is the name of the key source the key was loaded from
+	Source string `json:"source,omitempty"`
+	// Issuer is the token issuer the key source is trusted for, empty means any issuer
+	Issuer string `json:"issuer,omitempty"`
+	// Audiences pins the accepted audiences for tokens signed by this key, empty means the global ones apply
+	Audiences []string `json:"audiences,omitempty"`
+	// NotBefore and ExpiresAt bound when the key may verify tokens, zero when the source doesn't say
+	NotBefore time.Time `json:"not_before"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
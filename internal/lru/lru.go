@@ -0,0 +1,115 @@
+package lru
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// Cache is a size bounded, least recently used cache where every entry can carry its own expiry
+type Cache[K comparable, V any] struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[K]*list.Element
+	now   func() time.Time
+}
+
+// Get returns the value for key, expired entries are dropped and reported as a miss
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	element, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	e := element.Value.(*entry[K, V])
+	if !e.expiresAt.IsZero() && !c.now().Before(e.expiresAt) {
+		c.removeElement(element)
+		return zero, false
+	}
+	c.ll.MoveToFront(element)
+
+	return e.value, true
+}
+
+// Add stores value until expiresAt, a zero expiresAt keeps it until it is evicted
+func (c *Cache[K, V]) Add(key K, value V, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.ll.MoveToFront(element)
+		e := element.Value.(*entry[K, V])
+		e.value = value
+		e.expiresAt = expiresAt
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, expiresAt: expiresAt})
+	if c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *Cache[K, V]) Remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+// RemoveFunc drops every entry match reports true for and returns how many were dropped
+func (c *Cache[K, V]) RemoveFunc(match func(key K, value V) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for element := c.ll.Front(); element != nil; {
+		next := element.Next()
+		e := element.Value.(*entry[K, V])
+		if match(e.key, e.value) {
+			c.removeElement(element)
+			removed++
+		}
+		element = next
+	}
+
+	return removed
+}
+
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+func (c *Cache[K, V]) removeElement(element *list.Element) {
+	c.ll.Remove(element)
+	delete(c.items, element.Value.(*entry[K, V]).key)
+}
+
+// New creates a cache holding at most size entries, size must be positive
+func New[K comparable, V any](size int) *Cache[K, V] {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Cache[K, V]{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[K]*list.Element, size),
+		now:   time.Now,
+	}
+}
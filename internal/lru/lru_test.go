@@ -0,0 +1,79 @@
+package lru_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weeb-vip/gateway-proxy/internal/lru"
+)
+
+func TestCache(t *testing.T) {
+	t.Run("returns stored values", func(t *testing.T) {
+		c := lru.New[string, int](2)
+		c.Add("a", 1, time.Time{})
+
+		value, ok := c.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, 1, value)
+
+		_, ok = c.Get("b")
+		assert.False(t, ok)
+	})
+	t.Run("evicts the least recently used entry when full", func(t *testing.T) {
+		c := lru.New[string, int](2)
+		c.Add("a", 1, time.Time{})
+		c.Add("b", 2, time.Time{})
+		_, _ = c.Get("a")
+		c.Add("c", 3, time.Time{})
+
+		_, ok := c.Get("b")
+		assert.False(t, ok)
+		_, ok = c.Get("a")
+		assert.True(t, ok)
+		_, ok = c.Get("c")
+		assert.True(t, ok)
+		assert.Equal(t, 2, c.Len())
+	})
+	t.Run("drops entries once they expire", func(t *testing.T) {
+		c := lru.New[string, int](2)
+		c.Add("a", 1, time.Now().Add(-time.Second))
+		c.Add("b", 2, time.Now().Add(time.Minute))
+
+		_, ok := c.Get("a")
+		assert.False(t, ok)
+		_, ok = c.Get("b")
+		assert.True(t, ok)
+		assert.Equal(t, 1, c.Len())
+	})
+	t.Run("replaces the value and expiry of an existing key", func(t *testing.T) {
+		c := lru.New[string, int](2)
+		c.Add("a", 1, time.Now().Add(-time.Second))
+		c.Add("a", 2, time.Time{})
+
+		value, ok := c.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, 2, value)
+	})
+	t.Run("removes entries", func(t *testing.T) {
+		c := lru.New[string, int](2)
+		c.Add("a", 1, time.Time{})
+		c.Remove("a")
+
+		_, ok := c.Get("a")
+		assert.False(t, ok)
+	})
+	t.Run("removes the entries matching a predicate", func(t *testing.T) {
+		c := lru.New[string, int](4)
+		c.Add("a", 1, time.Time{})
+		c.Add("b", 2, time.Time{})
+		c.Add("c", 3, time.Time{})
+
+		removed := c.RemoveFunc(func(_ string, value int) bool { return value%2 == 1 })
+
+		assert.Equal(t, 2, removed)
+		assert.Equal(t, 1, c.Len())
+		_, ok := c.Get("b")
+		assert.True(t, ok)
+	})
+}
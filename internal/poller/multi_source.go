@@ -0,0 +1,168 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+)
+
+func (t taggingFetcher) FetchKeys(ctx context.Context) ([]keys.Key, error) {
+	result, err := t.fetcher.FetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tagged := make([]keys.Key, len(result))
+	for i, key := range result {
+		key.Source = t.name
+		key.Issuer = t.issuer
+		key.Audiences = t.audiences
+		tagged[i] = key
+	}
+
+	return tagged, nil
+}
+
+// Fetch refreshes every source, a failing source keeps serving its last good key set
+func (m *multiSourcePoller) Fetch() error {
+	var errs []error
+	for _, source := range m.sources {
+		if err := source.poller.Fetch(); err != nil {
+			errs = append(errs, fmt.Errorf("key source %s: %w", source.poller.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *multiSourcePoller) SetupBackgroundPolling(ctx context.Context, pollDuration time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	m.mu.Lock()
+	m.cancel = cancel
+	m.mu.Unlock()
+
+	for _, source := range m.sources {
+		duration := pollDuration
+		if source.pollDuration > 0 {
+			duration = source.pollDuration
+		}
+		go source.poller.poll(ctx, duration)
+	}
+}
+
+func (m *multiSourcePoller) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		m.cancel()
+	}
+	for _, source := range m.sources {
+		source.poller.Stop()
+	}
+}
+
+// Watch merges the events of every source, the channel is closed once every source's watch ended
+func (m *multiSourcePoller) Watch() (<-chan keys.Event, func()) {
+	watches := make([]<-chan keys.Event, len(m.sources))
+	unwatches := make([]func(), len(m.sources))
+	buffer := 0
+	for i, source := range m.sources {
+		watches[i], unwatches[i] = source.poller.Watch()
+		buffer += cap(watches[i])
+	}
+
+	merged := make(chan keys.Event, buffer)
+	var wg sync.WaitGroup
+	for _, events := range watches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for event := range events {
+				// like the store, a watcher that falls behind misses events rather than blocking the sources
+				select {
+				case merged <- event:
+				default:
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	return merged, func() {
+		for _, unwatch := range unwatches {
+			unwatch()
+		}
+	}
+}
+
+func (m *multiSourcePoller) Status() []Status {
+	statuses := make([]Status, len(m.sources))
+	for i, source := range m.sources {
+		statuses[i] = source.poller.status()
+	}
+
+	return statuses
+}
+
+func (m *multiSourcePoller) FindKeyByID(id string) (*keys.Key, error) {
+	key := m.findKeyByID(id)
+	if key != nil {
+		return key, nil
+	}
+	// one of the sources may have rotated, a partial failure shouldn't hide keys from the others
+	for _, source := range m.sources {
+		_ = source.poller.refresh()
+	}
+	key = m.findKeyByID(id)
+	if key == nil {
+		return nil, errors.New("key couldn't be found")
+	}
+
+	return key, nil
+}
+
+func (m *multiSourcePoller) findKeyByID(id string) *keys.Key {
+	// sources are checked in configuration order, so the first source wins on a kid collision
+	for _, source := range m.sources {
+		if key := source.poller.findKeyByID(id); key != nil {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// MultiSource creates a KeyManager merging the keys of several sources.
+// It only fails when none of the sources could be fetched initially.
+func MultiSource(sources []Source, opts ...Option) (KeyManager, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("no key sources configured")
+	}
+
+	log := logger.Get()
+	m := &multiSourcePoller{sources: make([]sourcePoller, 0, len(sources))}
+	var errs []error
+	for _, source := range sources {
+		fetcher := taggingFetcher{fetcher: source.Fetcher, name: source.Name, issuer: source.Issuer, audiences: source.Audiences}
+		p := newKeysPoller(source.Name, fetcher, opts...)
+		if err := p.start(); err != nil {
+			log.Warn().Err(err).Str("key_source", source.Name).Msg("Initial key fetch failed")
+			errs = append(errs, fmt.Errorf("key source %s: %w", source.Name, err))
+		}
+		m.sources = append(m.sources, sourcePoller{poller: p, pollDuration: source.PollDuration})
+	}
+
+	if len(errs) == len(sources) {
+		return nil, errors.Join(errs...)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,146 @@
+package poller_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"github.com/weeb-vip/gateway-proxy/internal/poller"
+)
+
+func TestMultiSource(t *testing.T) {
+	t.Run("returns error when no source is configured", func(t *testing.T) {
+		p, err := poller.MultiSource(nil)
+		assert.Error(t, err)
+		assert.Nil(t, p)
+	})
+	t.Run("returns error only when every source fails initially", func(t *testing.T) {
+		p, err := poller.MultiSource([]poller.Source{
+			{Name: "a", Fetcher: &mockFetcher{e: errors.New("a is down")}},
+			{Name: "b", Fetcher: &mockFetcher{e: errors.New("b is down")}},
+		})
+		assert.ErrorContains(t, err, "a is down")
+		assert.ErrorContains(t, err, "b is down")
+		assert.Nil(t, p)
+	})
+	t.Run("merges keys from every source and tags them with source and issuer", func(t *testing.T) {
+		p, err := poller.MultiSource([]poller.Source{
+			{Name: "key-management", Issuer: "weeb-vip", Fetcher: &mockFetcher{k: []keys.Key{{ID: "id1", Body: "body1"}}}},
+			{Name: "idp", Issuer: "https://idp.example.com", Fetcher: &mockFetcher{k: []keys.Key{{ID: "id2", Body: "body2"}}}},
+		})
+		require.NoError(t, err)
+
+		key, err := p.FindKeyByID("id1")
+		require.NoError(t, err)
+		assert.Equal(t, "key-management", key.Source)
+		assert.Equal(t, "weeb-vip", key.Issuer)
+
+		key, err = p.FindKeyByID("id2")
+		require.NoError(t, err)
+		assert.Equal(t, "idp", key.Source)
+		assert.Equal(t, "https://idp.example.com", key.Issuer)
+	})
+	t.Run("starts with the healthy sources when one of them is down", func(t *testing.T) {
+		p, err := poller.MultiSource([]poller.Source{
+			{Name: "down", Fetcher: &mockFetcher{e: errors.New("down")}},
+			{Name: "up", Fetcher: &mockFetcher{k: []keys.Key{{ID: "id1", Body: "body1"}}}},
+		})
+		require.NoError(t, err)
+
+		key, err := p.FindKeyByID("id1")
+		require.NoError(t, err)
+		assert.Equal(t, "up", key.Source)
+	})
+	t.Run("keeps serving the last good keys of a source whose refresh fails", func(t *testing.T) {
+		flaky := &mockFetcher{k: []keys.Key{{ID: "id1", Body: "body1"}}}
+		healthy := &mockFetcher{k: []keys.Key{{ID: "id2", Body: "body2"}}}
+		p, err := poller.MultiSource([]poller.Source{
+			{Name: "flaky", Fetcher: flaky},
+			{Name: "healthy", Fetcher: healthy},
+		})
+		require.NoError(t, err)
+
+		flaky.k, flaky.e = nil, errors.New("flaky is down")
+		healthy.k = []keys.Key{{ID: "id3", Body: "body3"}}
+		assert.ErrorContains(t, p.Fetch(), "flaky is down")
+
+		key, err := p.FindKeyByID("id1")
+		require.NoError(t, err)
+		assert.Equal(t, "body1", key.Body)
+
+		key, err = p.FindKeyByID("id3")
+		require.NoError(t, err)
+		assert.Equal(t, "healthy", key.Source)
+
+		_, err = p.FindKeyByID("id2")
+		assert.Error(t, err)
+	})
+	t.Run("refreshes every source once when a kid is unknown", func(t *testing.T) {
+		first := &mockFetcher{k: []keys.Key{{ID: "id1", Body: "body1"}}}
+		second := &mockFetcher{k: []keys.Key{{ID: "id2", Body: "body2"}}}
+		p, err := poller.MultiSource([]poller.Source{
+			{Name: "first", Fetcher: first},
+			{Name: "second", Fetcher: second},
+		})
+		require.NoError(t, err)
+
+		key, err := p.FindKeyByID("unknown")
+		assert.Error(t, err)
+		assert.Nil(t, key)
+		assert.Equal(t, 2, first.counter)
+		assert.Equal(t, 2, second.counter)
+	})
+	t.Run("reports the keys and the latest fetch of every source", func(t *testing.T) {
+		flaky := &mockFetcher{k: []keys.Key{{ID: "id1", Body: "body1"}}}
+		healthy := &mockFetcher{k: []keys.Key{{ID: "id2", Body: "body2"}, {ID: "id3", Body: "body3"}}}
+		p, err := poller.MultiSource([]poller.Source{
+			{Name: "flaky", Fetcher: flaky},
+			{Name: "healthy", Fetcher: healthy},
+		})
+		require.NoError(t, err)
+		initial := p.Status()
+
+		flaky.k, flaky.e = nil, errors.New("flaky is down")
+		_ = p.Fetch()
+		statuses := p.Status()
+
+		require.Len(t, statuses, 2)
+		assert.Equal(t, "flaky", statuses[0].Source)
+		assert.Equal(t, 1, statuses[0].Keys)
+		assert.Equal(t, "flaky is down", statuses[0].LastError)
+		assert.Equal(t, initial[0].LastSuccess, statuses[0].LastSuccess)
+		assert.Equal(t, "healthy", statuses[1].Source)
+		assert.Equal(t, 2, statuses[1].Keys)
+		assert.Empty(t, statuses[1].LastError)
+		assert.False(t, statuses[1].LastSuccess.Before(initial[1].LastSuccess))
+	})
+	t.Run("reports key set changes of every source until stopped", func(t *testing.T) {
+		first := &mockFetcher{k: []keys.Key{{ID: "id1", Body: "body1"}}}
+		second := &mockFetcher{k: []keys.Key{{ID: "id2", Body: "body2"}}}
+		p, err := poller.MultiSource([]poller.Source{
+			{Name: "first", Fetcher: first},
+			{Name: "second", Fetcher: second},
+		})
+		require.NoError(t, err)
+		events, _ := p.Watch()
+
+		first.k = []keys.Key{{ID: "id1", Body: "rotated"}}
+		second.k = nil
+		require.NoError(t, p.Fetch())
+
+		var changed, removed []string
+		for i := 0; i < 2; i++ {
+			event := <-events
+			changed = append(changed, event.Changed...)
+			removed = append(removed, event.Removed...)
+		}
+		assert.Equal(t, []string{"id1"}, changed)
+		assert.Equal(t, []string{"id2"}, removed)
+
+		p.Stop()
+		_, open := <-events
+		assert.False(t, open)
+	})
+}
@@ -1,37 +1,243 @@
 package poller
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math/rand/v2"
 	"time"
 
-	"github.com/weeb-vip/gateway-proxy/internal/container"
-	"github.com/weeb-vip/gateway-proxy/internal/generics"
 	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+	"github.com/weeb-vip/gateway-proxy/metrics"
+	"github.com/weeb-vip/gateway-proxy/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func (k keysPoller) Fetch() error {
-	result, err := k.fetcher.FetchKeys()
+var errRefreshLimited = errors.New("keys were refreshed too recently")
+
+func (k *keysPoller) Fetch() error {
+	return k.fetch(context.Background())
+}
+
+// fetch loads the keys of the source, cancelling ctx abandons a fetch in flight.
+// Every fetch runs in its own span, the fetchers propagate it to the key source
+func (k *keysPoller) fetch(ctx context.Context) error {
+	ctx, span := tracing.GetTracer(ctx).Start(ctx, "keys.fetch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("key_source", k.name)),
+	)
+	defer span.End()
+
+	start := time.Now()
+	result, err := k.fetcher.FetchKeys(ctx)
+	duration := time.Since(start)
+	k.record(err)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attribute.Int("keys", len(result)))
+
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	metrics.GetAppMetrics().KeyFetchMetric(float64(duration.Nanoseconds())/float64(time.Millisecond), outcome)
+	logger.LogKeyFetch(ctx, err == nil, duration, len(result))
+	if err != nil {
+		return err
+	}
+
+	k.logChanges(k.store.Replace(result))
+	if k.snapshots != nil {
+		if err := k.snapshots.save(k.name, result, time.Now()); err != nil {
+			log := logger.Get()
+			log.Warn().Err(err).Str("key_source", k.name).Msg("Failed to save the key snapshot")
+		}
+	}
+
+	return nil
+}
+
+// start makes the initial fetch, falling back to the snapshot when the source can't be reached.
+// Background polling then retries the source with the usual backoff
+func (k *keysPoller) start() error {
+	err := k.Fetch()
+	if err == nil || k.snapshots == nil {
 		return err
 	}
 
-	k.container.ReplaceWith(result)
+	restored, fetchedAt, restoreErr := k.snapshots.load(k.name)
+	if restoreErr != nil {
+		return fmt.Errorf("%w, no snapshot to start from: %w", err, restoreErr)
+	}
+	k.store.Replace(restored)
+	k.mu.Lock()
+	k.lastSuccess = fetchedAt
+	k.restored = true
+	k.mu.Unlock()
+
+	log := logger.Get()
+	log.Warn().
+		Err(err).
+		Str("key_source", k.name).
+		Time("fetched_at", fetchedAt).
+		Int("keys", len(restored)).
+		Msg("Key source unreachable, starting from the key snapshot")
 
 	return nil
 }
 
-func (k keysPoller) SetupBackgroundPolling(pollDuration time.Duration) {
-	go func() {
-		for {
-			time.Sleep(pollDuration)
-			_ = k.Fetch() // we can safely ignore the error
+// logChanges reports rotations, a fetch returning the same keys changes nothing
+func (k *keysPoller) logChanges(event keys.Event) {
+	if len(event.Added)+len(event.Changed)+len(event.Retired)+len(event.Removed) == 0 {
+		return
+	}
+	log := logger.Get()
+	log.Info().
+		Str("key_source", k.name).
+		Uint64("version", event.Version).
+		Strs("added", event.Added).
+		Strs("changed", event.Changed).
+		Strs("retired", event.Retired).
+		Strs("removed", event.Removed).
+		Msg("Key set changed")
+}
+
+func (k *keysPoller) record(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.lastError = err
+	k.restored = false
+	if err != nil {
+		k.failures++
+		return
+	}
+	k.lastSuccess = time.Now()
+	k.failures = 0
+}
+
+// refresh fetches on demand: concurrent callers share one fetch, and fetches closer than refreshInterval are refused
+func (k *keysPoller) refresh() error {
+	_, err, _ := k.refreshes.Do("refresh", func() (any, error) {
+		k.mu.Lock()
+		if time.Since(k.lastRefresh) < k.refreshInterval {
+			k.mu.Unlock()
+			return nil, errRefreshLimited
 		}
-	}()
+		k.lastRefresh = time.Now()
+		k.mu.Unlock()
+
+		return nil, k.Fetch()
+	})
+
+	return err
+}
+
+func (k *keysPoller) SetupBackgroundPolling(ctx context.Context, pollDuration time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	k.mu.Lock()
+	k.cancel = cancel
+	k.mu.Unlock()
+
+	go k.poll(ctx, pollDuration)
+}
+
+// poll fetches every pollDuration, failed fetches are retried sooner with an exponential backoff
+func (k *keysPoller) poll(ctx context.Context, pollDuration time.Duration) {
+	log := logger.Get()
+	// a source that failed to start is retried without waiting for a whole poll duration
+	failures := k.status().Failures
+	for {
+		timer := time.NewTimer(nextPoll(pollDuration, k.retryDelay, failures))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := k.fetch(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			log.Warn().Err(err).Str("key_source", k.name).Int("failures", failures).Msg("Key source refresh failed, keeping last known keys")
+			continue
+		}
+		failures = 0
+	}
+}
+
+// nextPoll waits pollDuration after a success. After failures it starts from retryDelay and doubles up to
+// pollDuration, with jitter so replicas don't retry in lockstep against a recovering key service
+func nextPoll(pollDuration time.Duration, retryDelay time.Duration, failures int) time.Duration {
+	if failures == 0 {
+		return jitter(pollDuration, pollJitter)
+	}
+
+	delay := pollDuration
+	if retryDelay < pollDuration && failures < 32 {
+		delay = min(retryDelay<<(failures-1), pollDuration)
+	}
+
+	// the upper half is random, the wait never drops below half the backoff
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// jitter spreads d by up to the given fraction in either direction
+func jitter(d time.Duration, fraction float64) time.Duration {
+	spread := time.Duration(float64(d) * fraction)
+	if spread <= 0 {
+		return d
+	}
+
+	return d - spread + rand.N(2*spread+1)
+}
+
+func (k *keysPoller) Stop() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.cancel != nil {
+		k.cancel()
+	}
+	for _, unwatch := range k.unwatch {
+		unwatch()
+	}
+	k.unwatch = nil
 }
 
-func (k keysPoller) FindKeyByID(id string) (*keys.Key, error) {
+func (k *keysPoller) Watch() (<-chan keys.Event, func()) {
+	events, unwatch := k.store.Watch()
+	k.mu.Lock()
+	k.unwatch = append(k.unwatch, unwatch)
+	k.mu.Unlock()
+
+	return events, unwatch
+}
+
+func (k *keysPoller) Status() []Status {
+	return []Status{k.status()}
+}
+
+func (k *keysPoller) status() Status {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	status := Status{Source: k.name, Keys: len(k.store.Keys()), LastSuccess: k.lastSuccess, Failures: k.failures, Restored: k.restored}
+	if k.lastError != nil {
+		status.LastError = k.lastError.Error()
+	}
+
+	return status
+}
+
+func (k *keysPoller) FindKeyByID(id string) (*keys.Key, error) {
 	// try to find
-	// if not found, fetch once
+	// if not found, refresh once, unless another lookup just did
 	// try to find again
 	// if not found, it's an error
 
@@ -39,8 +245,8 @@ func (k keysPoller) FindKeyByID(id string) (*keys.Key, error) {
 	if key != nil {
 		return key, nil
 	}
-	err := k.Fetch()
-	if err != nil {
+	err := k.refresh()
+	if err != nil && !errors.Is(err, errRefreshLimited) {
 		return nil, err
 	}
 	key = k.findKeyByID(id)
@@ -51,20 +257,60 @@ func (k keysPoller) FindKeyByID(id string) (*keys.Key, error) {
 	return key, nil
 }
 
-func (k keysPoller) findKeyByID(id string) *keys.Key {
-	return generics.First(k.container.GetLatest(), func(item keys.Key) bool {
-		return item.ID == id
-	})
+func (k *keysPoller) findKeyByID(id string) *keys.Key {
+	key, ok := k.store.Get(id)
+	if !ok {
+		return nil
+	}
+
+	return key
 }
 
-func Keys(fetcher keys.Fetcher) (KeyManager, error) {
-	key, err := fetcher.FetchKeys()
-	if err != nil {
+// WithRefreshInterval sets the least time between two fetches triggered by unknown kids,
+// so a flood of tokens with made up kids can't hammer the key source
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(p *keysPoller) {
+		p.refreshInterval = interval
+	}
+}
+
+// WithKeyRetirement keeps keys that disappeared from their source for the window, so tokens they signed
+// before a rotation keep verifying
+func WithKeyRetirement(window time.Duration) Option {
+	return func(p *keysPoller) {
+		p.retirement = window
+	}
+}
+
+// WithRetryDelay sets the first wait after a failed background fetch
+func WithRetryDelay(delay time.Duration) Option {
+	return func(p *keysPoller) {
+		if delay > 0 {
+			p.retryDelay = delay
+		}
+	}
+}
+
+func newKeysPoller(name string, fetcher keys.Fetcher, opts ...Option) *keysPoller {
+	p := &keysPoller{
+		name:            name,
+		fetcher:         fetcher,
+		refreshInterval: defaultRefreshInterval,
+		retryDelay:      defaultRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.store = keys.NewStore(keys.WithRetirement(p.retirement))
+
+	return p
+}
+
+func Keys(fetcher keys.Fetcher, opts ...Option) (KeyManager, error) {
+	p := newKeysPoller("", fetcher, opts...)
+	if err := p.start(); err != nil {
 		return nil, err
 	}
-	c := container.New(key)
-	return keysPoller{
-		container: c,
-		fetcher:   fetcher,
-	}, nil
+
+	return p, nil
 }
@@ -1,11 +1,18 @@
 package poller_test
 
 import (
+	"context"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/weeb-vip/gateway-proxy/internal/keys"
 	"github.com/weeb-vip/gateway-proxy/internal/poller"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type mockFetcher struct {
@@ -14,11 +21,35 @@ type mockFetcher struct {
 	counter int
 }
 
-func (r *mockFetcher) FetchKeys() ([]keys.Key, error) {
+func (r *mockFetcher) FetchKeys(context.Context) ([]keys.Key, error) {
 	r.counter = r.counter + 1
 	return r.k, r.e
 }
 
+// countingFetcher can be called from the polling goroutine, the next failing calls return an error
+type countingFetcher struct {
+	calls   atomic.Int32
+	failing atomic.Int32
+}
+
+func (c *countingFetcher) FetchKeys(context.Context) ([]keys.Key, error) {
+	c.calls.Add(1)
+	if c.failing.Add(-1) >= 0 {
+		return nil, errors.New("key service unavailable")
+	}
+	return []keys.Key{{ID: "id1", Body: "body1"}}, nil
+}
+
+// spanFetcher records the span the fetch ran in
+type spanFetcher struct {
+	span trace.SpanContext
+}
+
+func (s *spanFetcher) FetchKeys(ctx context.Context) ([]keys.Key, error) {
+	s.span = trace.SpanContextFromContext(ctx)
+	return []keys.Key{{ID: "id1", Body: "body1"}}, nil
+}
+
 func TestKeys(t *testing.T) {
 	t.Run("returns error while initializing if the initial fetching fails", func(t *testing.T) {
 		p, err := poller.Keys(&mockFetcher{k: nil, e: errors.New("some error")})
@@ -41,4 +72,114 @@ func TestKeys(t *testing.T) {
 		assert.Nil(t, key)
 		assert.Equal(t, 2, successMockFetcher.counter)
 	})
+	t.Run("stopping ends background polling and keeps the loaded keys", func(t *testing.T) {
+		fetcher := &countingFetcher{}
+		p, err := poller.Keys(fetcher)
+		assert.NoError(t, err)
+
+		p.SetupBackgroundPolling(context.Background(), 5*time.Millisecond)
+		assert.Eventually(t, func() bool { return fetcher.calls.Load() >= 3 }, time.Second, time.Millisecond)
+		p.Stop()
+		p.Stop()
+		time.Sleep(20 * time.Millisecond)
+		calls := fetcher.calls.Load()
+		time.Sleep(30 * time.Millisecond)
+
+		assert.Equal(t, calls, fetcher.calls.Load())
+		key, err := p.FindKeyByID("id1")
+		assert.NoError(t, err)
+		assert.Equal(t, "body1", key.Body)
+	})
+	t.Run("cancelling the context ends background polling", func(t *testing.T) {
+		fetcher := &countingFetcher{}
+		p, err := poller.Keys(fetcher)
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		p.SetupBackgroundPolling(ctx, 5*time.Millisecond)
+		assert.Eventually(t, func() bool { return fetcher.calls.Load() >= 3 }, time.Second, time.Millisecond)
+		cancel()
+		time.Sleep(20 * time.Millisecond)
+		calls := fetcher.calls.Load()
+		time.Sleep(30 * time.Millisecond)
+
+		assert.Equal(t, calls, fetcher.calls.Load())
+	})
+	t.Run("failed background fetches are retried before the next poll", func(t *testing.T) {
+		fetcher := &countingFetcher{}
+		p, err := poller.Keys(fetcher, poller.WithRetryDelay(20*time.Millisecond))
+		assert.NoError(t, err)
+		fetcher.failing.Store(2)
+
+		// without the backoff the third fetch would only happen after three poll durations
+		p.SetupBackgroundPolling(context.Background(), 200*time.Millisecond)
+		defer p.Stop()
+		assert.Eventually(t, func() bool {
+			status := p.Status()[0]
+			return status.Failures == 2 && status.LastError == "key service unavailable"
+		}, 400*time.Millisecond, time.Millisecond)
+		assert.Eventually(t, func() bool { return fetcher.calls.Load() == 4 }, 100*time.Millisecond, time.Millisecond)
+
+		status := p.Status()[0]
+		assert.Equal(t, 0, status.Failures)
+		assert.Empty(t, status.LastError)
+		assert.Equal(t, 1, status.Keys)
+	})
+	t.Run("lookups of unknown kids share one fetch and are rate limited", func(t *testing.T) {
+		fetcher := &countingFetcher{}
+		p, err := poller.Keys(fetcher)
+		assert.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := p.FindKeyByID("made-up")
+				assert.Error(t, err)
+			}()
+		}
+		wg.Wait()
+		_, err = p.FindKeyByID("made-up")
+		assert.Error(t, err)
+
+		assert.Equal(t, int32(2), fetcher.calls.Load())
+		key, err := p.FindKeyByID("id1")
+		assert.NoError(t, err)
+		assert.Equal(t, "body1", key.Body)
+	})
+	t.Run("a zero refresh interval fetches on every unknown kid", func(t *testing.T) {
+		fetcher := &countingFetcher{}
+		p, err := poller.Keys(fetcher, poller.WithRefreshInterval(0))
+		assert.NoError(t, err)
+
+		_, _ = p.FindKeyByID("made-up")
+		_, _ = p.FindKeyByID("made-up")
+
+		assert.Equal(t, int32(3), fetcher.calls.Load())
+	})
+	t.Run("keys rotated out keep verifying for the retirement window", func(t *testing.T) {
+		fetcher := &mockFetcher{k: []keys.Key{{ID: "id1", Body: "body1"}}}
+		p, err := poller.Keys(fetcher, poller.WithKeyRetirement(time.Hour))
+		assert.NoError(t, err)
+
+		fetcher.k = []keys.Key{{ID: "id2", Body: "body2"}}
+		assert.NoError(t, p.Fetch())
+
+		key, err := p.FindKeyByID("id1")
+		assert.NoError(t, err)
+		assert.Equal(t, "body1", key.Body)
+		assert.Equal(t, 1, p.Status()[0].Keys)
+	})
+	t.Run("fetches run in a span the fetcher can propagate", func(t *testing.T) {
+		previous := otel.GetTracerProvider()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider())
+		defer otel.SetTracerProvider(previous)
+		fetcher := &spanFetcher{}
+
+		_, err := poller.Keys(fetcher)
+
+		assert.NoError(t, err)
+		assert.True(t, fetcher.span.IsValid())
+	})
 }
@@ -0,0 +1,113 @@
+package poller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+)
+
+// snapshotFile keeps the last good key set of every source on disk, so the gateway can start while
+// a key source is unreachable. It only ever holds public keys
+type snapshotFile struct {
+	path   string
+	maxAge time.Duration
+	mu     sync.Mutex
+}
+
+type snapshot struct {
+	Sources map[string]sourceSnapshot `json:"sources"`
+}
+
+type sourceSnapshot struct {
+	FetchedAt time.Time  `json:"fetched_at"`
+	Keys      []keys.Key `json:"keys"`
+}
+
+// save replaces the keys of source, the file is swapped in whole so a crash never leaves it half written
+func (s *snapshotFile) save(source string, fetched []keys.Key, fetchedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.read()
+	if err != nil {
+		// an unreadable snapshot is replaced rather than blocking every later save
+		current = snapshot{}
+	}
+	if current.Sources == nil {
+		current.Sources = make(map[string]sourceSnapshot)
+	}
+	current.Sources[source] = sourceSnapshot{FetchedAt: fetchedAt, Keys: fetched}
+
+	body, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(body); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), s.path)
+}
+
+// load returns the keys of source, unless they are older than maxAge
+func (s *snapshotFile) load(source string) ([]keys.Key, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.read()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	saved, ok := current.Sources[source]
+	if !ok || len(saved.Keys) == 0 {
+		return nil, time.Time{}, fmt.Errorf("key snapshot has no keys for %q", source)
+	}
+	if age := time.Since(saved.FetchedAt); s.maxAge > 0 && age > s.maxAge {
+		return nil, time.Time{}, fmt.Errorf("key snapshot for %q is %s old", source, age.Round(time.Second))
+	}
+
+	return saved.Keys, saved.FetchedAt, nil
+}
+
+func (s *snapshotFile) read() (snapshot, error) {
+	var current snapshot
+	body, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return current, nil
+	}
+	if err != nil {
+		return current, fmt.Errorf("failed to read key snapshot: %w", err)
+	}
+	if err := json.Unmarshal(body, &current); err != nil {
+		return current, fmt.Errorf("failed to parse key snapshot: %w", err)
+	}
+
+	return current, nil
+}
+
+// WithSnapshot saves every successful fetch to path, and starts sources that can't be fetched initially
+// from keys saved there within maxAge. Zero maxAge accepts snapshots of any age
+func WithSnapshot(path string, maxAge time.Duration) Option {
+	snapshots := &snapshotFile{path: path, maxAge: maxAge}
+
+	return func(p *keysPoller) {
+		if path != "" {
+			p.snapshots = snapshots
+		}
+	}
+}
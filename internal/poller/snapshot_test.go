@@ -0,0 +1,88 @@
+package poller_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"github.com/weeb-vip/gateway-proxy/internal/poller"
+)
+
+func TestWithSnapshot(t *testing.T) {
+	down := &mockFetcher{e: errors.New("key service unavailable")}
+
+	t.Run("starts from the keys of the last successful fetch when the source is down", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		_, err := poller.Keys(&mockFetcher{k: []keys.Key{{ID: "id1", Body: "body1"}}}, poller.WithSnapshot(path, time.Hour))
+		require.NoError(t, err)
+
+		p, err := poller.Keys(down, poller.WithSnapshot(path, time.Hour))
+		require.NoError(t, err)
+
+		key, err := p.FindKeyByID("id1")
+		require.NoError(t, err)
+		assert.Equal(t, "body1", key.Body)
+		status := p.Status()[0]
+		assert.Equal(t, 1, status.Keys)
+		assert.Equal(t, "key service unavailable", status.LastError)
+		assert.WithinDuration(t, time.Now(), status.LastSuccess, time.Minute)
+	})
+	t.Run("reports restored keys until the next fetch", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		_, err := poller.Keys(&mockFetcher{k: []keys.Key{{ID: "id1", Body: "body1"}}}, poller.WithSnapshot(path, time.Hour))
+		require.NoError(t, err)
+		p, err := poller.Keys(down, poller.WithSnapshot(path, time.Hour))
+		require.NoError(t, err)
+
+		assert.True(t, p.Status()[0].Restored)
+		assert.Error(t, p.Fetch())
+		assert.False(t, p.Status()[0].Restored)
+	})
+	t.Run("keeps the keys of every source apart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		_, err := poller.MultiSource([]poller.Source{
+			{Name: "key-management", Fetcher: &mockFetcher{k: []keys.Key{{ID: "id1", Body: "body1"}}}},
+			{Name: "idp", Issuer: "https://idp.example.com", Fetcher: &mockFetcher{k: []keys.Key{{ID: "id2", Body: "body2"}}}},
+		}, poller.WithSnapshot(path, time.Hour))
+		require.NoError(t, err)
+
+		p, err := poller.MultiSource([]poller.Source{
+			{Name: "key-management", Fetcher: &mockFetcher{k: []keys.Key{{ID: "id3", Body: "body3"}}}},
+			{Name: "idp", Issuer: "https://idp.example.com", Fetcher: down},
+		}, poller.WithSnapshot(path, time.Hour))
+		require.NoError(t, err)
+
+		key, err := p.FindKeyByID("id2")
+		require.NoError(t, err)
+		assert.Equal(t, "idp", key.Source)
+		assert.Equal(t, "https://idp.example.com", key.Issuer)
+		_, err = p.FindKeyByID("id3")
+		assert.NoError(t, err)
+	})
+	t.Run("refuses snapshots older than the max age", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		body, err := json.Marshal(map[string]any{"sources": map[string]any{
+			"": map[string]any{"fetched_at": time.Now().Add(-2 * time.Hour), "keys": []keys.Key{{ID: "id1", Body: "body1"}}},
+		}})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, body, 0o600))
+
+		p, err := poller.Keys(down, poller.WithSnapshot(path, time.Hour))
+
+		assert.ErrorContains(t, err, "key service unavailable")
+		assert.ErrorContains(t, err, "old")
+		assert.Nil(t, p)
+	})
+	t.Run("fails to start without a snapshot", func(t *testing.T) {
+		p, err := poller.Keys(down, poller.WithSnapshot(filepath.Join(t.TempDir(), "keys.json"), time.Hour))
+
+		assert.ErrorContains(t, err, "key service unavailable")
+		assert.Nil(t, p)
+	})
+}
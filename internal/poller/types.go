@@ -1,18 +1,95 @@
 package poller
 
 import (
-	"github.com/weeb-vip/gateway-proxy/internal/container"
+	"context"
 	"github.com/weeb-vip/gateway-proxy/internal/keys"
+	"golang.org/x/sync/singleflight"
+	"sync"
 	"time"
 )
 
+const (
+	// defaultRefreshInterval is the least time between two fetches triggered by unknown kids
+	defaultRefreshInterval = 10 * time.Second
+	// defaultRetryDelay is the first wait after a failed background fetch, it doubles up to the poll duration
+	defaultRetryDelay = 5 * time.Second
+	// pollJitter spreads the background fetches of replicas started together
+	pollJitter = 0.1
+)
+
 type keysPoller struct {
-	container container.Container[[]keys.Key]
-	fetcher   keys.Fetcher
+	name    string
+	store   *keys.Store
+	fetcher keys.Fetcher
+
+	retirement      time.Duration
+	refreshInterval time.Duration
+	retryDelay      time.Duration
+	refreshes       singleflight.Group
+	snapshots       *snapshotFile
+
+	mu          sync.Mutex
+	lastRefresh time.Time
+	lastSuccess time.Time
+	restored    bool
+	lastError   error
+	failures    int
+	cancel      context.CancelFunc
+	unwatch     []func()
 }
 
 type KeyManager interface {
 	Fetch() error
-	SetupBackgroundPolling(pollingDuration time.Duration)
+	// SetupBackgroundPolling refreshes the keys every pollingDuration until ctx is done or Stop is called
+	SetupBackgroundPolling(ctx context.Context, pollingDuration time.Duration)
 	FindKeyByID(id string) (*keys.Key, error)
+	// Stop ends background polling and closes the watches, keys already loaded can still be found
+	Stop()
+	// Watch reports changes of the key set until the returned function is called or Stop is
+	Watch() (<-chan keys.Event, func())
+	// Status describes every key source, in configuration order
+	Status() []Status
+}
+
+// Status describes the keys loaded from a source and how its latest fetches went
+type Status struct {
+	Source      string    `json:"source,omitempty"`
+	Keys        int       `json:"keys"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	// Failures counts the fetches that failed since the last success
+	Failures int `json:"failures"`
+	// Restored is set while the keys come from the snapshot, until the first fetch after the start
+	Restored bool `json:"restored,omitempty"`
+}
+
+type Option func(p *keysPoller)
+
+// Source is a named key source, keys loaded from it are tagged with its name and issuer
+type Source struct {
+	Name      string
+	Issuer    string
+	Audiences []string
+	Fetcher   keys.Fetcher
+	// PollDuration overrides the polling duration for this source when non-zero
+	PollDuration time.Duration
+}
+
+type taggingFetcher struct {
+	fetcher   keys.Fetcher
+	name      string
+	issuer    string
+	audiences []string
+}
+
+type sourcePoller struct {
+	poller       *keysPoller
+	pollDuration time.Duration
+}
+
+type multiSourcePoller struct {
+	sources []sourcePoller
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
 }
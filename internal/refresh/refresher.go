@@ -0,0 +1,85 @@
+package refresh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Refresh returns new tokens for the refresh token, calling the auth service at most once per token at a time.
+// Results aren't kept once the call completed, a rotated refresh token always reaches the auth service
+// so its reuse detection sees replays
+func (r *Refresher) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	hash := sha256.Sum256([]byte(refreshToken))
+	result, err, _ := r.group.Do(hex.EncodeToString(hash[:]), func() (any, error) {
+		// the caller's context may be cancelled while other requests still wait for the result
+		return r.call(context.WithoutCancel(ctx), refreshToken)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*Tokens), nil
+}
+
+func (r *Refresher) call(ctx context.Context, refreshToken string) (*Tokens, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.AddCookie(&http.Cookie{Name: RefreshTokenCookie, Value: refreshToken})
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRefreshFailed, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: auth service responded with status %d", ErrRefreshFailed, response.StatusCode)
+	}
+
+	tokens := &Tokens{SetCookies: response.Header.Values("Set-Cookie")}
+	for _, cookie := range response.Cookies() {
+		switch cookie.Name {
+		case r.accessCookie:
+			tokens.AccessToken = cookie.Value
+		case RefreshTokenCookie:
+			tokens.RefreshToken = cookie.Value
+		}
+	}
+	if tokens.AccessToken == "" {
+		return nil, fmt.Errorf("%w: no %s cookie in the response", ErrRefreshFailed, r.accessCookie)
+	}
+
+	return tokens, nil
+}
+
+// WithAccessTokenCookie sets the name of the access token cookie the auth service sets, AccessTokenCookie by default
+func WithAccessTokenCookie(name string) Option {
+	return func(r *Refresher) {
+		if name != "" {
+			r.accessCookie = name
+		}
+	}
+}
+
+func NewRefresher(endpoint string, timeout time.Duration, opts ...Option) *Refresher {
+	r := &Refresher{
+		endpoint:     endpoint,
+		client:       &http.Client{Timeout: timeout},
+		accessCookie: AccessTokenCookie,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
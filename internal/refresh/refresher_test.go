@@ -0,0 +1,102 @@
+package refresh_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/refresh"
+)
+
+func authService(t *testing.T, calls *atomic.Int32, delay time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(delay)
+		cookie, err := r.Cookie("refresh_token")
+		if err != nil || cookie.Value != "refresh-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "access_token", Value: "access-2", Path: "/", HttpOnly: true})
+		http.SetCookie(w, &http.Cookie{Name: "refresh_token", Value: "refresh-2", Path: "/", HttpOnly: true})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestRefresher(t *testing.T) {
+	t.Run("trades the refresh token for new cookies", func(t *testing.T) {
+		calls := &atomic.Int32{}
+		refresher := refresh.NewRefresher(authService(t, calls, 0).URL, time.Second)
+
+		tokens, err := refresher.Refresh(context.Background(), "refresh-1")
+
+		require.NoError(t, err)
+		assert.Equal(t, "access-2", tokens.AccessToken)
+		assert.Equal(t, "refresh-2", tokens.RefreshToken)
+		assert.Len(t, tokens.SetCookies, 2)
+	})
+	t.Run("concurrent refreshes of the same token call the auth service once", func(t *testing.T) {
+		calls := &atomic.Int32{}
+		refresher := refresh.NewRefresher(authService(t, calls, 50*time.Millisecond).URL, time.Second)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tokens, err := refresher.Refresh(context.Background(), "refresh-1")
+				assert.NoError(t, err)
+				assert.Equal(t, "access-2", tokens.AccessToken)
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), calls.Load())
+	})
+	t.Run("sends a rotated refresh token to the auth service again", func(t *testing.T) {
+		calls := &atomic.Int32{}
+		refresher := refresh.NewRefresher(authService(t, calls, 0).URL, time.Second)
+
+		_, err := refresher.Refresh(context.Background(), "refresh-1")
+		require.NoError(t, err)
+		_, err = refresher.Refresh(context.Background(), "refresh-1")
+		require.NoError(t, err)
+
+		assert.Equal(t, int32(2), calls.Load())
+	})
+	t.Run("reads the configured access token cookie", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "access-2"})
+		}))
+		defer server.Close()
+
+		tokens, err := refresh.NewRefresher(server.URL, time.Second, refresh.WithAccessTokenCookie("session")).Refresh(context.Background(), "refresh-1")
+
+		require.NoError(t, err)
+		assert.Equal(t, "access-2", tokens.AccessToken)
+	})
+	t.Run("returns an error when the auth service refuses the token", func(t *testing.T) {
+		calls := &atomic.Int32{}
+		refresher := refresh.NewRefresher(authService(t, calls, 0).URL, time.Second)
+
+		_, err := refresher.Refresh(context.Background(), "revoked")
+
+		assert.ErrorIs(t, err, refresh.ErrRefreshFailed)
+	})
+	t.Run("returns an error when no access token is set", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		_, err := refresh.NewRefresher(server.URL, time.Second).Refresh(context.Background(), "refresh-1")
+
+		assert.ErrorIs(t, err, refresh.ErrRefreshFailed)
+	})
+}
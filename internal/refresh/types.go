@@ -0,0 +1,35 @@
+package refresh
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+)
+
+var ErrRefreshFailed = errors.New("token refresh failed")
+
+// Tokens is the outcome of a successful refresh
+type Tokens struct {
+	AccessToken string
+	// RefreshToken is set when the auth service rotated the refresh token
+	RefreshToken string
+	// SetCookies are the Set-Cookie headers of the auth service, passed on to the client unchanged
+	SetCookies []string
+}
+
+// Refresher exchanges refresh tokens for new access tokens at the auth service.
+// Concurrent refreshes of the same token share one call.
+type Refresher struct {
+	endpoint     string
+	client       *http.Client
+	group        singleflight.Group
+	accessCookie string
+}
+
+type Option func(r *Refresher)
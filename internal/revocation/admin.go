@@ -0,0 +1,73 @@
+package revocation
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+)
+
+// AdminHandler accepts revocations posted with the admin token as bearer token
+func AdminHandler(store *Store, adminToken string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, adminToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var revocation Revocation
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&revocation); err != nil {
+			http.Error(w, "invalid revocation", http.StatusBadRequest)
+			return
+		}
+		if (revocation.TokenID == "") == (revocation.Subject == "") {
+			http.Error(w, "exactly one of jti and subject is required", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if revocation.TokenID != "" {
+			err = store.RevokeToken(r.Context(), revocation.TokenID, timeOrZero(revocation.ExpiresAt))
+		} else {
+			err = store.RevokeSubject(r.Context(), revocation.Subject, timeOrZero(revocation.Before))
+		}
+		if err != nil {
+			log := logger.FromCtx(r.Context())
+			log.Error().Err(err).Msg("Failed to store revocation")
+			http.Error(w, "failed to store revocation", http.StatusInternalServerError)
+			return
+		}
+
+		log := logger.FromCtx(r.Context())
+		log.Info().
+			Str("jti", revocation.TokenID).
+			Str("subject", revocation.Subject).
+			Msg("Revocation added")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func authorized(r *http.Request, adminToken string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || adminToken == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return *t
+}
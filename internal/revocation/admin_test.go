@@ -0,0 +1,63 @@
+package revocation_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+	"github.com/weeb-vip/gateway-proxy/internal/revocation"
+)
+
+func TestAdminHandler(t *testing.T) {
+	post := func(handler http.Handler, token string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/admin/revocations", strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	t.Run("revokes a token", func(t *testing.T) {
+		store, _ := newStore(t)
+		handler := revocation.AdminHandler(store, "secret")
+
+		response := post(handler, "secret", `{"jti":"token-1"}`)
+
+		assert.Equal(t, http.StatusNoContent, response.Code)
+		revoked, err := store.IsRevoked(&jwt.ParsedJWT{ID: "token-1"})
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+	t.Run("revokes a subject", func(t *testing.T) {
+		store, _ := newStore(t)
+		handler := revocation.AdminHandler(store, "secret")
+
+		response := post(handler, "secret", `{"subject":"user-1"}`)
+
+		assert.Equal(t, http.StatusNoContent, response.Code)
+		revoked, err := store.IsRevoked(&jwt.ParsedJWT{Subject: getPointer("user-1"), IssuedAt: time.Now().Add(-time.Minute)})
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+	t.Run("rejects requests without the admin token", func(t *testing.T) {
+		store, _ := newStore(t)
+		handler := revocation.AdminHandler(store, "secret")
+
+		assert.Equal(t, http.StatusUnauthorized, post(handler, "", `{"jti":"token-1"}`).Code)
+		assert.Equal(t, http.StatusUnauthorized, post(handler, "wrong", `{"jti":"token-1"}`).Code)
+	})
+	t.Run("rejects ambiguous revocations", func(t *testing.T) {
+		store, _ := newStore(t)
+		handler := revocation.AdminHandler(store, "secret")
+
+		assert.Equal(t, http.StatusBadRequest, post(handler, "secret", `{}`).Code)
+		assert.Equal(t, http.StatusBadRequest, post(handler, "secret", `{"jti":"token-1","subject":"user-1"}`).Code)
+	})
+}
@@ -0,0 +1,184 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+	"github.com/weeb-vip/gateway-proxy/internal/lru"
+)
+
+// IsRevoked checks the token's jti and subject, a Redis failure lets the token through unless the store fails closed
+func (s *Store) IsRevoked(token *jwt.ParsedJWT) (bool, error) {
+	var lookupKeys []string
+	if token.ID != "" {
+		lookupKeys = append(lookupKeys, tokenKeyPrefix+token.ID)
+	}
+	if token.Subject != nil && *token.Subject != "" {
+		lookupKeys = append(lookupKeys, subjectKeyPrefix+*token.Subject)
+	}
+	if len(lookupKeys) == 0 {
+		return false, nil
+	}
+
+	statuses, err := s.lookup(lookupKeys)
+	if err != nil {
+		if s.failClosed {
+			return false, err
+		}
+		log := logger.Get()
+		log.Warn().Err(err).Msg("Revocation lookup failed, accepting token")
+		return false, nil
+	}
+
+	for _, st := range statuses {
+		if st.revoked {
+			return true, nil
+		}
+		if !st.before.IsZero() && !token.IssuedAt.After(st.before) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// lookup resolves keys from memory first and fetches the misses in a single round trip
+func (s *Store) lookup(lookupKeys []string) ([]status, error) {
+	statuses := make([]status, 0, len(lookupKeys))
+	var misses []string
+	for _, key := range lookupKeys {
+		if st, ok := s.lookups.Get(key); ok {
+			statuses = append(statuses, st)
+			continue
+		}
+		misses = append(misses, key)
+	}
+	if len(misses) == 0 {
+		return statuses, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	values, err := s.client.MGet(ctx, misses...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := s.now().Add(s.cacheTTL)
+	for i, key := range misses {
+		st, err := parseStatus(key, values[i])
+		if err != nil {
+			return nil, err
+		}
+		s.lookups.Add(key, st, expiresAt)
+		statuses = append(statuses, st)
+	}
+
+	return statuses, nil
+}
+
+func parseStatus(key string, value any) (status, error) {
+	raw, ok := value.(string)
+	if !ok {
+		return status{}, nil
+	}
+	if strings.HasPrefix(key, tokenKeyPrefix) {
+		return status{revoked: true}, nil
+	}
+	seconds, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return status{}, errors.New("malformed subject revocation " + key)
+	}
+
+	return status{before: time.Unix(seconds, 0)}, nil
+}
+
+// RevokeToken revokes a single token by jti until it would have expired anyway
+func (s *Store) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if tokenID == "" {
+		return errors.New("missing jti")
+	}
+	ttl := s.maxLifetime
+	if !expiresAt.IsZero() {
+		ttl = expiresAt.Sub(s.now())
+	}
+	if ttl <= 0 {
+		// the token is already expired, nothing left to revoke
+		return nil
+	}
+
+	key := tokenKeyPrefix + tokenID
+	if err := s.client.Set(ctx, key, "1", ttl).Err(); err != nil {
+		return err
+	}
+	s.lookups.Add(key, status{revoked: true}, s.now().Add(s.cacheTTL))
+
+	return nil
+}
+
+// RevokeSubject revokes every token of the subject issued at or before the given time
+func (s *Store) RevokeSubject(ctx context.Context, subject string, before time.Time) error {
+	if subject == "" {
+		return errors.New("missing subject")
+	}
+	if before.IsZero() {
+		before = s.now()
+	}
+	// iat has second precision, rounding up keeps tokens issued earlier in the same second revoked
+	cutoff := before.Truncate(time.Second)
+	if cutoff.Before(before) {
+		cutoff = cutoff.Add(time.Second)
+	}
+
+	key := subjectKeyPrefix + subject
+	if err := s.client.Set(ctx, key, strconv.FormatInt(cutoff.Unix(), 10), s.maxLifetime).Err(); err != nil {
+		return err
+	}
+	s.lookups.Add(key, status{before: cutoff}, s.now().Add(s.cacheTTL))
+
+	return nil
+}
+
+// WithCache sets how many lookups are kept in memory and for how long.
+// Other replicas pick up a revocation once their cached lookup expires.
+func WithCache(size int, ttl time.Duration) Option {
+	return func(s *Store) {
+		s.lookups = lru.New[string, status](size)
+		s.cacheTTL = ttl
+	}
+}
+
+// WithMaxTokenLifetime bounds how long revocations are kept, it has to cover the longest lived token
+func WithMaxTokenLifetime(lifetime time.Duration) Option {
+	return func(s *Store) {
+		s.maxLifetime = lifetime
+	}
+}
+
+// WithFailClosed refuses tokens when the revocation store can't be reached
+func WithFailClosed(failClosed bool) Option {
+	return func(s *Store) {
+		s.failClosed = failClosed
+	}
+}
+
+func NewStore(client *redis.Client, opts ...Option) *Store {
+	s := &Store{
+		client:      client,
+		lookups:     lru.New[string, status](10000),
+		cacheTTL:    5 * time.Second,
+		maxLifetime: 24 * time.Hour,
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
@@ -0,0 +1,95 @@
+package revocation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/internal/jwt"
+	"github.com/weeb-vip/gateway-proxy/internal/revocation"
+)
+
+func getPointer[T any](input T) *T {
+	return &input
+}
+
+func newStore(t *testing.T, opts ...revocation.Option) (*revocation.Store, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return revocation.NewStore(client, opts...), server
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("revokes a token by jti until it expires", func(t *testing.T) {
+		store, server := newStore(t)
+		expiresAt := time.Now().Add(time.Hour)
+
+		require.NoError(t, store.RevokeToken(ctx, "token-1", expiresAt))
+
+		revoked, err := store.IsRevoked(&jwt.ParsedJWT{ID: "token-1"})
+		require.NoError(t, err)
+		assert.True(t, revoked)
+		revoked, err = store.IsRevoked(&jwt.ParsedJWT{ID: "token-2"})
+		require.NoError(t, err)
+		assert.False(t, revoked)
+		assert.InDelta(t, time.Hour.Seconds(), server.TTL("revocation:jti:token-1").Seconds(), 5)
+	})
+	t.Run("revokes tokens of a subject issued before the cutoff", func(t *testing.T) {
+		store, _ := newStore(t)
+		cutoff := time.Now()
+
+		require.NoError(t, store.RevokeSubject(ctx, "user-1", cutoff))
+
+		revoked, err := store.IsRevoked(&jwt.ParsedJWT{Subject: getPointer("user-1"), IssuedAt: cutoff.Add(-time.Minute)})
+		require.NoError(t, err)
+		assert.True(t, revoked)
+		revoked, err = store.IsRevoked(&jwt.ParsedJWT{Subject: getPointer("user-1"), IssuedAt: cutoff.Add(time.Minute)})
+		require.NoError(t, err)
+		assert.False(t, revoked)
+		revoked, err = store.IsRevoked(&jwt.ParsedJWT{Subject: getPointer("user-2"), IssuedAt: cutoff.Add(-time.Minute)})
+		require.NoError(t, err)
+		assert.False(t, revoked)
+	})
+	t.Run("sees revocations made by other replicas once the cached lookup expires", func(t *testing.T) {
+		store, server := newStore(t, revocation.WithCache(100, 50*time.Millisecond))
+		other := revocation.NewStore(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+		token := &jwt.ParsedJWT{ID: "token-1"}
+
+		revoked, err := store.IsRevoked(token)
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		require.NoError(t, other.RevokeToken(ctx, "token-1", time.Time{}))
+		revoked, err = store.IsRevoked(token)
+		require.NoError(t, err)
+		assert.False(t, revoked, "the negative lookup is still cached")
+
+		time.Sleep(60 * time.Millisecond)
+		revoked, err = store.IsRevoked(token)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+	t.Run("accepts tokens when Redis is unavailable", func(t *testing.T) {
+		store, server := newStore(t)
+		server.Close()
+
+		revoked, err := store.IsRevoked(&jwt.ParsedJWT{ID: "token-1"})
+		assert.NoError(t, err)
+		assert.False(t, revoked)
+	})
+	t.Run("returns an error when Redis is unavailable and failing closed", func(t *testing.T) {
+		store, server := newStore(t, revocation.WithFailClosed(true))
+		server.Close()
+
+		_, err := store.IsRevoked(&jwt.ParsedJWT{ID: "token-1"})
+		assert.Error(t, err)
+	})
+}
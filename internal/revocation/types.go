@@ -0,0 +1,45 @@
+package revocation
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/weeb-vip/gateway-proxy/internal/lru"
+)
+
+const (
+	tokenKeyPrefix   = "revocation:jti:"
+	subjectKeyPrefix = "revocation:sub:"
+	// lookupTimeout bounds the Redis round trip added to requests carrying an uncached token
+	lookupTimeout = 250 * time.Millisecond
+)
+
+// status is what the in-memory front remembers about a jti or a subject
+type status struct {
+	revoked bool
+	// before is set for subjects, tokens issued at or before it are revoked
+	before time.Time
+}
+
+// Store keeps revocations in Redis so every replica sees them, lookups are cached in memory for cacheTTL
+type Store struct {
+	client      *redis.Client
+	lookups     *lru.Cache[string, status]
+	cacheTTL    time.Duration
+	maxLifetime time.Duration
+	failClosed  bool
+	now         func() time.Time
+}
+
+// Option configures the store
+type Option func(*Store)
+
+// Revocation is the body accepted by the admin API, exactly one of TokenID and Subject is set
+type Revocation struct {
+	TokenID string `json:"jti"`
+	// ExpiresAt is when the revoked token expires anyway, the maximum token lifetime is assumed when missing
+	ExpiresAt *time.Time `json:"expires_at"`
+	Subject   string     `json:"subject"`
+	// Before revokes the subject's tokens issued up to this time, now when missing
+	Before *time.Time `json:"before"`
+}
@@ -0,0 +1,113 @@
+package servertls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/internal/logger"
+)
+
+// fileState identifies a version of a file on disk, a change of either field means it was replaced
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// certificateReloader serves the latest key pair found on disk. Handshakes ask for the certificate every time,
+// so a reload only affects new connections and established ones are never dropped
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	certState   fileState
+	keyState    fileState
+}
+
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.certificate, nil
+}
+
+// reload loads the key pair again when either file changed, a pair that fails to load keeps the current one
+func (r *certificateReloader) reload() (bool, error) {
+	certState, err := stat(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyState, err := stat(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := r.certificate != nil && certState == r.certState && keyState == r.keyState
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.certificate = &certificate
+	r.certState = certState
+	r.keyState = keyState
+	r.mu.Unlock()
+
+	return true, nil
+}
+
+// watch checks the files every interval until ctx is done
+func (r *certificateReloader) watch(ctx context.Context, interval time.Duration) {
+	log := logger.Get()
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			reloaded, err := r.reload()
+			if err != nil {
+				// certificate and key are rarely replaced at the same instant, the next check picks up the pair
+				log.Warn().Err(err).Str("cert_file", r.certFile).Msg("Keeping the current TLS certificate")
+				continue
+			}
+			if reloaded {
+				log.Info().Str("cert_file", r.certFile).Msg("Reloaded TLS certificate")
+			}
+		}
+	}()
+}
+
+func stat(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+
+	return fileState{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+func newCertificateReloader(certFile string, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
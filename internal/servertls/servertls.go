@@ -0,0 +1,142 @@
+package servertls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/weeb-vip/gateway-proxy/config"
+)
+
+const (
+	// ClientAuthVerifyIfGiven accepts clients without a certificate, certificates that are sent have to verify
+	ClientAuthVerifyIfGiven = "verify-if-given"
+	// ClientAuthRequire refuses the handshake of clients without a valid certificate
+	ClientAuthRequire = "require"
+)
+
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// New builds the TLS configuration of the listener, client certificates are verified when a client CA is configured.
+// The certificate files are checked for changes every TLSReloadSeconds until ctx is done
+func New(ctx context.Context, cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("TLS requires a certificate and a key file")
+	}
+	minVersion, ok := versions[cfg.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q, expected \"1.2\" or \"1.3\"", cfg.TLSMinVersion)
+	}
+	cipherSuites, err := cipherSuiteIDs(cfg.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	reloader, err := newCertificateReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		// no NextProtos, net/http offers h2 itself once it set up HTTP/2 for the listener
+	}
+	if cfg.TLSReloadSeconds > 0 {
+		reloader.watch(ctx, time.Duration(cfg.TLSReloadSeconds)*time.Second)
+	}
+	if cfg.TLSClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pool, err := loadCertPool(cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientCAs = pool
+	switch cfg.TLSClientAuth {
+	case ClientAuthVerifyIfGiven:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown TLS client auth %q, expected %q or %q", cfg.TLSClientAuth, ClientAuthVerifyIfGiven, ClientAuthRequire)
+	}
+
+	return tlsConfig, nil
+}
+
+// cipherSuiteIDs resolves suite names, only the ones Go considers secure are accepted.
+// TLS 1.3 suites aren't configurable, the list only applies to TLS 1.2 handshakes
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	// without one of these net/http silently serves HTTP/1.1 only
+	for _, id := range ids {
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return ids, nil
+		}
+	}
+
+	return nil, errors.New("HTTP/2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 in the cipher suites")
+}
+
+// KeyService builds the TLS configuration used to reach the key service, nil when nothing is configured.
+// A certificate and key authenticate the gateway with mTLS, a CA bundle replaces the system roots
+func KeyService(cfg *config.Config) (*tls.Config, error) {
+	if cfg.KeyServiceCertFile == "" && cfg.KeyServiceKeyFile == "" && cfg.KeyServiceCAFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.KeyServiceCertFile != "" || cfg.KeyServiceKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.KeyServiceCertFile, cfg.KeyServiceKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key service client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	if cfg.KeyServiceCAFile != "" {
+		pool, err := loadCertPool(cfg.KeyServiceCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(body) {
+		return nil, fmt.Errorf("CA bundle %s holds no PEM certificate", path)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,282 @@
+package servertls_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weeb-vip/gateway-proxy/config"
+	"github.com/weeb-vip/gateway-proxy/internal/servertls"
+)
+
+type certificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// issue signs a certificate with parent, or self-signs it when parent is nil
+func issue(t *testing.T, template *x509.Certificate, parent *certificate) certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return certificate{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func writeFile(t *testing.T, dir string, name string, body []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, body, 0o600))
+
+	return path
+}
+
+func keyPEM(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test-ca"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	server := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "gateway"}, DNSNames: []string{"localhost"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, &ca)
+	client := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "batch-importer"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, &ca)
+	cfg := &config.Config{
+		TLSCertFile:     writeFile(t, dir, "server.pem", server.pem),
+		TLSKeyFile:      writeFile(t, dir, "server-key.pem", keyPEM(t, server.key)),
+		TLSClientCAFile: writeFile(t, dir, "ca.pem", ca.pem),
+		TLSClientAuth:   servertls.ClientAuthVerifyIfGiven,
+		TLSMinVersion:   "1.2",
+	}
+
+	serve := func(t *testing.T, cfg *config.Config) string {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		tlsConfig, err := servertls.New(ctx, cfg)
+		require.NoError(t, err)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server := &http.Server{
+			TLSConfig: tlsConfig,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(r.TLS.VerifiedChains) > 0 {
+					_, _ = w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+				}
+			}),
+		}
+		go func() { _ = server.ServeTLS(listener, "", "") }()
+		t.Cleanup(func() { _ = server.Close() })
+
+		return "https://" + listener.Addr().String()
+	}
+	clientFor := func(certificates ...tls.Certificate) *http.Client {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		return &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: certificates,
+		}, ForceAttemptHTTP2: true}}
+	}
+	clientCertificate := tls.Certificate{Certificate: [][]byte{client.cert.Raw}, PrivateKey: client.key}
+
+	t.Run("verifies client certificates against the CA bundle", func(t *testing.T) {
+		url := serve(t, cfg)
+
+		response, err := clientFor(clientCertificate).Get(url)
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		body := make([]byte, 64)
+		n, _ := response.Body.Read(body)
+		assert.Equal(t, "batch-importer", string(body[:n]))
+	})
+	t.Run("accepts clients without a certificate when verifying only given ones", func(t *testing.T) {
+		url := serve(t, cfg)
+
+		response, err := clientFor().Get(url)
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+	t.Run("refuses clients without a certificate when required", func(t *testing.T) {
+		required := *cfg
+		required.TLSClientAuth = servertls.ClientAuthRequire
+		url := serve(t, &required)
+
+		_, err := clientFor().Get(url)
+		assert.Error(t, err)
+	})
+	t.Run("refuses certificates of other CAs", func(t *testing.T) {
+		other := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other-ca"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+		forged := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "batch-importer"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, &other)
+		required := *cfg
+		required.TLSClientAuth = servertls.ClientAuthRequire
+		url := serve(t, &required)
+
+		_, err := clientFor(tls.Certificate{Certificate: [][]byte{forged.cert.Raw}, PrivateKey: forged.key}).Get(url)
+		assert.Error(t, err)
+	})
+	t.Run("negotiates HTTP/2", func(t *testing.T) {
+		url := serve(t, cfg)
+
+		response, err := clientFor().Get(url)
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, 2, response.ProtoMajor)
+	})
+	t.Run("refuses versions below the minimum", func(t *testing.T) {
+		modern := *cfg
+		modern.TLSMinVersion = "1.3"
+		url := serve(t, &modern)
+		client := clientFor()
+		client.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+
+		_, err := client.Get(url)
+		assert.Error(t, err)
+	})
+	t.Run("restricts TLS 1.2 to the configured cipher suites", func(t *testing.T) {
+		restricted := *cfg
+		restricted.TLSCipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+		url := serve(t, &restricted)
+		client := clientFor()
+		client.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+
+		response, err := client.Get(url)
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, 2, response.ProtoMajor)
+		assert.Equal(t, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, response.TLS.CipherSuite)
+	})
+	t.Run("reloads the certificate when the files change", func(t *testing.T) {
+		dir := t.TempDir()
+		reloading := *cfg
+		reloading.TLSCertFile = writeFile(t, dir, "server.pem", server.pem)
+		reloading.TLSKeyFile = writeFile(t, dir, "server-key.pem", keyPEM(t, server.key))
+		reloading.TLSReloadSeconds = 1
+		url := serve(t, &reloading)
+		servedName := func() string {
+			// a fresh transport per call, so every request makes a new handshake
+			response, err := clientFor().Get(url)
+			if err != nil {
+				return err.Error()
+			}
+			response.Body.Close()
+			return response.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		require.Equal(t, "gateway", servedName())
+
+		renewed := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "gateway-renewed"}, DNSNames: []string{"localhost"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, &ca)
+		writeFile(t, dir, "server.pem", renewed.pem)
+		writeFile(t, dir, "server-key.pem", keyPEM(t, renewed.key))
+
+		assert.Eventually(t, func() bool {
+			return servedName() == "gateway-renewed"
+		}, 5*time.Second, 100*time.Millisecond)
+	})
+	t.Run("returns an error for an unknown cipher suite", func(t *testing.T) {
+		unknown := *cfg
+		unknown.TLSCipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+
+		_, err := servertls.New(context.Background(), &unknown)
+		assert.Error(t, err)
+	})
+	t.Run("returns an error for cipher suites HTTP/2 can't use", func(t *testing.T) {
+		restricted := *cfg
+		restricted.TLSCipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}
+
+		_, err := servertls.New(context.Background(), &restricted)
+		assert.Error(t, err)
+	})
+	t.Run("returns an error without a certificate", func(t *testing.T) {
+		_, err := servertls.New(context.Background(), &config.Config{})
+
+		assert.Error(t, err)
+	})
+	t.Run("returns an error for an unknown client auth mode", func(t *testing.T) {
+		unknown := *cfg
+		unknown.TLSClientAuth = "maybe"
+
+		_, err := servertls.New(context.Background(), &unknown)
+		assert.Error(t, err)
+	})
+}
+
+func TestKeyService(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test-ca"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	keyManagement := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "key-management"}, IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, &ca)
+	gateway := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "gateway"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, &ca)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+	}))
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{keyManagement.cert.Raw}, PrivateKey: keyManagement.key}},
+		ClientCAs:    roots,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	t.Run("authenticates the gateway to the key service with its client certificate", func(t *testing.T) {
+		tlsConfig, err := servertls.KeyService(&config.Config{
+			KeyServiceCertFile: writeFile(t, dir, "gateway.pem", gateway.pem),
+			KeyServiceKeyFile:  writeFile(t, dir, "gateway-key.pem", keyPEM(t, gateway.key)),
+			KeyServiceCAFile:   writeFile(t, dir, "ca.pem", ca.pem),
+		})
+		require.NoError(t, err)
+
+		client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		response, err := client.Get(server.URL)
+		require.NoError(t, err)
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "gateway", string(body))
+	})
+	t.Run("returns no configuration when nothing is configured", func(t *testing.T) {
+		tlsConfig, err := servertls.KeyService(&config.Config{})
+		assert.NoError(t, err)
+		assert.Nil(t, tlsConfig)
+	})
+	t.Run("returns error when the key of the certificate is missing", func(t *testing.T) {
+		_, err := servertls.KeyService(&config.Config{KeyServiceCertFile: writeFile(t, dir, "only-cert.pem", gateway.pem)})
+		assert.Error(t, err)
+	})
+	t.Run("returns error when the CA bundle holds no certificate", func(t *testing.T) {
+		_, err := servertls.KeyService(&config.Config{KeyServiceCAFile: writeFile(t, dir, "empty.pem", []byte("nothing"))})
+		assert.Error(t, err)
+	})
+}
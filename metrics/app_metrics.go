@@ -58,6 +58,17 @@ func (m *AppMetrics) JWTValidationMetric(duration float64, result string) {
 	m.metricsImpl.DatabaseMetric(duration, labels)
 }
 
+// JWTLeewayMetric counts tokens whose time claim was only accepted thanks to the clock skew leeway
+func (m *AppMetrics) JWTLeewayMetric(claim string, issuer string) {
+	labels := map[string]string{
+		"service": m.defaultTags["service"],
+		"claim":   claim,
+		"issuer":  issuer,
+		"env":     m.defaultTags["env"],
+	}
+	m.metricsImpl.CountMetric("jwt_leeway_accepted_total", labels)
+}
+
 // KeyFetchMetric records key fetch performance metrics
 func (m *AppMetrics) KeyFetchMetric(duration float64, result string) {
 	labels := metricsLib.DatabaseMetricLabels{
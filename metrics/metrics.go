@@ -68,6 +68,9 @@ func initMetrics(prometheusInstance *prometheus.PrometheusClient) {
 		1000,
 	})
 
+	// Tokens accepted only thanks to the clock skew leeway
+	prometheusInstance.CreateCounterVec("jwt_leeway_accepted_total", "tokens accepted within leeway", []string{"service", "claim", "issuer", "env"})
+
 	// Key fetch duration metrics
 	prometheusInstance.CreateHistogramVec("key_fetch_duration_histogram_milliseconds", "key fetch millisecond", []string{"service", "result", "env"}, []float64{
 		100,